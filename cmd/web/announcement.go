@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseSwitch reads an on or off setting
+func parseSwitch(args []string) (bool, bool) {
+	if len(args) != 1 {
+		return false, false
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		return true, true
+	case "off":
+		return false, true
+	}
+
+	return false, false
+}
+
+// announcementCommand shows or, for the owner, changes whether only
+// moderators may post in the chatroom
+func (app *application) announcementCommand(args CommandArgs, c *Client) error {
+	if len(args.Args) == 0 {
+		room, err := app.roomModel.Get(c.room())
+		if err != nil {
+			return err
+		}
+
+		if room.Announcement {
+			return sendSystemMessage(c, "This is an announcement chatroom, only moderators can post")
+		}
+		return sendSystemMessage(c, "Everyone in this chatroom can post")
+	}
+
+	on, ok := parseSwitch(args.Args)
+	if !ok {
+		return errCommandUsage
+	}
+
+	owner, err := app.ownedGroupRoom(c)
+	if err != nil || !owner {
+		return err
+	}
+
+	if err := app.roomModel.SetAnnouncement(c.room(), on); err != nil {
+		return err
+	}
+
+	if err := app.roomUpdated(c.room()); err != nil {
+		return err
+	}
+
+	if on {
+		return app.announce(c.room(), fmt.Sprintf("%s made this an announcement chatroom, only moderators can post", c.username))
+	}
+	return app.announce(c.room(), fmt.Sprintf("%s opened the chatroom up, everyone can post again", c.username))
+}
+
+// autojoinCommand lets admins have every new user added to an announcement chatroom
+func (app *application) autojoinCommand(args CommandArgs, c *Client) error {
+	on, ok := parseSwitch(args.Args)
+	if !ok {
+		return errCommandUsage
+	}
+
+	admin, err := app.userModel.IsAdmin(c.email)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return sendSystemMessage(c, "Only admins can add new users to chatrooms automatically")
+	}
+
+	room, err := app.roomModel.Get(c.room())
+	if err != nil {
+		return err
+	}
+	if on && !room.Announcement {
+		return sendSystemMessage(c, "Only announcement chatrooms can have new users added automatically")
+	}
+
+	if err := app.roomModel.SetAutoJoin(c.room(), on); err != nil {
+		return err
+	}
+
+	if on {
+		return sendSystemMessage(c, "New users will be added to this chatroom when they sign up")
+	}
+	return sendSystemMessage(c, "New users will no longer be added to this chatroom")
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gochat.ayonchakroborty.net/internal/models"
+	"gochat.ayonchakroborty.net/internal/validator"
+)
+
+// isArchived reports whether the chatroom has been archived, which makes it read only
+func (app *application) isArchived(chatroom string) (bool, error) {
+	room, err := app.roomModel.Get(chatroom)
+	if err != nil {
+		return false, err
+	}
+
+	return !room.Archived.IsZero(), nil
+}
+
+// ownedGroupRoom checks c is the owner of the group chatroom they are in,
+// telling them why not if they are not
+func (app *application) ownedGroupRoom(c *Client) (bool, error) {
+	membership, err := app.chatroomModel.GetMembership(c.room(), c.email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return false, err
+	}
+	if membership == nil || membership.Private {
+		return false, sendSystemMessage(c, "Only group chatrooms you are in can be changed")
+	}
+	if membership.Role != models.RoleOwner {
+		return false, sendSystemMessage(c, "Only the owner can do that")
+	}
+
+	return true, nil
+}
+
+// renameCommand moves the chatroom, its history and its settings to a new name
+func (app *application) renameCommand(args CommandArgs, c *Client) error {
+	name := strings.TrimSpace(args.Raw)
+	if name == "" {
+		return errCommandUsage
+	}
+
+	owner, err := app.ownedGroupRoom(c)
+	if err != nil || !owner {
+		return err
+	}
+
+	if problem := roomNameProblem(name, c.room()); problem != "" {
+		return sendSystemMessage(c, problem)
+	}
+
+	from := c.room()
+
+	if err := app.chatroomModel.Rename(from, name); err != nil {
+		if errors.Is(err, models.ErrDuplicateChatroom) {
+			return sendSystemMessage(c, fmt.Sprintf("There is already a chatroom called %s", name))
+		}
+		return err
+	}
+
+	if err := app.roomMoved(from, name); err != nil {
+		return err
+	}
+
+	return app.announce(name, fmt.Sprintf("%s renamed the chatroom from %s to %s", c.username, from, name))
+}
+
+// roomNameProblem says why a chatroom called current cannot be given name, or
+// returns an empty string if it can
+func roomNameProblem(name, current string) string {
+	switch {
+	case !validator.MaxChars(name, 255):
+		return "Chatroom names cannot be more than 255 characters long"
+	case validator.Matches(name, validator.EmailRX), strings.HasPrefix(name, "Private chatroom for "), models.IsGroupChat(name):
+		return "That name is kept for private chatrooms"
+	case name == current:
+		return fmt.Sprintf("This chatroom is already called %s", name)
+	}
+
+	return ""
+}
+
+// roomMoved follows a chatroom to its new name in the search index and in
+// every open socket, and tells its members
+func (app *application) roomMoved(from, to string) error {
+	if err := app.searchIndex.Rename(from, to); err != nil {
+		app.logger.Error("failed to rename chatroom in the search index, run /reindex", "error", err)
+	}
+
+	data, err := json.Marshal(RoomRenamedEvent{From: from, To: to})
+	if err != nil {
+		return fmt.Errorf("failed to marshal room rename: %v", err)
+	}
+
+	// sockets in the room follow it to its new name
+	app.wsManager.RLock()
+	for client := range app.wsManager.clients {
+		client.moveRoom(from, to)
+	}
+	app.wsManager.RUnlock()
+
+	members, err := app.chatroomModel.GetUsersList(to)
+	if err != nil {
+		return err
+	}
+
+	for _, email := range members {
+		app.wsManager.sendToUser(email, Event{Type: EventRoomRenamed, Payload: data})
+	}
+
+	return nil
+}
+
+func (app *application) archiveCommand(args CommandArgs, c *Client) error {
+	return app.setArchived(args, c, true)
+}
+
+func (app *application) unarchiveCommand(args CommandArgs, c *Client) error {
+	return app.setArchived(args, c, false)
+}
+
+// setArchived archives the chatroom, leaving it read only and out of room
+// lists, or brings it back
+func (app *application) setArchived(args CommandArgs, c *Client, archived bool) error {
+	if len(args.Args) != 0 {
+		return errCommandUsage
+	}
+
+	owner, err := app.ownedGroupRoom(c)
+	if err != nil || !owner {
+		return err
+	}
+
+	current, err := app.isArchived(c.room())
+	if err != nil {
+		return err
+	}
+	if current == archived {
+		if archived {
+			return sendSystemMessage(c, "This chatroom is already archived")
+		}
+		return sendSystemMessage(c, "This chatroom is not archived")
+	}
+
+	if err := app.roomModel.SetArchived(c.room(), archived); err != nil {
+		return err
+	}
+
+	if err := app.roomUpdated(c.room()); err != nil {
+		return err
+	}
+
+	if archived {
+		return app.announce(c.room(), fmt.Sprintf("%s archived the chatroom, it is now read only", c.username))
+	}
+	return app.announce(c.room(), fmt.Sprintf("%s brought the chatroom back from the archive", c.username))
+}
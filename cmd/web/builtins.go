@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gochat.ayonchakroborty.net/internal/models"
+	"gochat.ayonchakroborty.net/internal/validator"
+)
+
+func (app *application) setupCommands() error {
+	commands := []Command{
+		{
+			Name:        "me",
+			Usage:       "/me <action>",
+			Description: "Describe what you are doing",
+			Handler:     app.meCommand,
+		},
+		{
+			Name:        "topic",
+			Usage:       "/topic [new topic]",
+			Description: "Show or change the chatroom's topic",
+			Handler:     app.topicCommand,
+		},
+		{
+			Name:        "invite",
+			Usage:       "/invite <email>, /invite link [1h|1d|7d|never] [max uses], /invite links, /invite revoke <token>",
+			Description: "Invite someone to this chatroom or manage invite links",
+			Handler:     app.inviteCommand,
+		},
+		{
+			Name:        "joinpolicy",
+			Usage:       "/joinpolicy [open|knock|invite]",
+			Description: "Show or change who may join this chatroom",
+			Handler:     app.joinPolicyCommand,
+		},
+		{
+			Name:        "role",
+			Usage:       "/role [<email> [owner|moderator|member]]",
+			Description: "Show roles or, as the owner, change them",
+			Handler:     app.roleCommand,
+		},
+		{
+			Name:        "kick",
+			Usage:       "/kick <email> [reason]",
+			Description: "Remove someone from this chatroom, moderators only",
+			Handler:     app.kickCommand,
+		},
+		{
+			Name:        "ban",
+			Usage:       "/ban <email> [30m|2h|1d|never] [reason]",
+			Description: "Remove someone and keep them out, moderators only",
+			Handler:     app.banCommand,
+		},
+		{
+			Name:        "unban",
+			Usage:       "/unban <email>",
+			Description: "Let a banned user join again, moderators only",
+			Handler:     app.unbanCommand,
+		},
+		{
+			Name:        "mute",
+			Usage:       "/mute <email> [30m|2h|1d|never] [reason]",
+			Description: "Stop someone posting, moderators only",
+			Handler:     app.muteCommand,
+		},
+		{
+			Name:        "unmute",
+			Usage:       "/unmute <email>",
+			Description: "Let a muted user post again, moderators only",
+			Handler:     app.unmuteCommand,
+		},
+		{
+			Name:        "modlog",
+			Usage:       "/modlog",
+			Description: "List the latest kicks, bans and mutes, moderators only",
+			Handler:     app.modlogCommand,
+		},
+		{
+			Name:        "rename",
+			Usage:       "/rename <new name>",
+			Description: "Rename this chatroom, keeping its history, owner only",
+			Handler:     app.renameCommand,
+		},
+		{
+			Name:        "archive",
+			Usage:       "/archive",
+			Description: "Make this chatroom read only and hide it from room lists, owner only",
+			Handler:     app.archiveCommand,
+		},
+		{
+			Name:        "unarchive",
+			Usage:       "/unarchive",
+			Description: "Bring this chatroom back from the archive, owner only",
+			Handler:     app.unarchiveCommand,
+		},
+		{
+			Name:        "announcement",
+			Usage:       "/announcement [on|off]",
+			Description: "Show or, as the owner, change whether only moderators can post",
+			Handler:     app.announcementCommand,
+		},
+		{
+			Name:        "autojoin",
+			Usage:       "/autojoin on|off",
+			Description: "Add new users to this announcement chatroom when they sign up, admins only",
+			Handler:     app.autojoinCommand,
+		},
+		{
+			Name:        "slowmode",
+			Usage:       "/slowmode [30s|1m|5m|off]",
+			Description: "Show or, as a moderator, change how long members wait between messages",
+			Handler:     app.slowModeCommand,
+		},
+		{
+			Name:        "add",
+			Usage:       "/add <email>",
+			Description: "Bring someone else into this group chat",
+			Handler:     app.addCommand,
+		},
+		{
+			Name:        "convert",
+			Usage:       "/convert <name>",
+			Description: "Turn this group chat into a named invite only chatroom that you own",
+			Handler:     app.convertCommand,
+		},
+		{
+			Name:        "leave",
+			Usage:       "/leave",
+			Description: "Leave this chatroom",
+			Handler:     app.leaveCommand,
+		},
+		{
+			Name:        "who",
+			Usage:       "/who",
+			Description: "List the members of this chatroom",
+			Handler:     app.whoCommand,
+		},
+		{
+			Name:        "help",
+			Usage:       "/help",
+			Description: "List the commands you can use",
+			Handler:     app.helpCommand,
+		},
+		{
+			Name:        "disappear",
+			Usage:       "/disappear 1h|1d|7d|off",
+			Description: "Delete this private chatroom's messages after a while",
+			Handler:     app.disappearCommand,
+		},
+		{
+			Name:        "retention",
+			Usage:       "/retention [<days>|forever|default|preview]",
+			Description: "Show or, as an admin, change how long this chatroom keeps messages",
+			Handler:     app.retentionCommand,
+		},
+		{
+			Name:        "remind",
+			Usage:       "/remind me|#chatroom <when> [to] <what>, /remind list, /remind delete <id>",
+			Description: "Set a reminder for yourself or a chatroom",
+			Handler:     app.remindCommand,
+		},
+		{
+			Name:        "reindex",
+			Usage:       "/reindex",
+			Description: "Rebuild the search index from every message, admins only",
+			Handler:     app.reindexCommand,
+		},
+	}
+
+	for _, cmd := range commands {
+		if err := app.wsManager.RegisterCommand(cmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (app *application) meCommand(args CommandArgs, c *Client) error {
+	if args.Raw == "" {
+		return errCommandUsage
+	}
+
+	allowed, err := app.checkPost(c, c.room())
+	if err != nil || !allowed {
+		return err
+	}
+
+	return app.postMessage(SendMessageEvent{
+		Message:  fmt.Sprintf("* %s %s", c.username, args.Raw),
+		From:     c.username,
+		Email:    c.email,
+		Chatroom: c.room(),
+	})
+}
+
+func (app *application) topicCommand(args CommandArgs, c *Client) error {
+	member, err := app.chatroomModel.IsMember(c.room(), c.email)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return sendSystemMessage(c, "You are not in this chatroom")
+	}
+
+	if args.Raw == "" {
+		room, err := app.roomModel.Get(c.room())
+		if err != nil {
+			return err
+		}
+
+		if room.Topic == "" {
+			return sendSystemMessage(c, "This chatroom has no topic")
+		}
+		return sendSystemMessage(c, "Topic: "+room.Topic)
+	}
+
+	allowed, err := app.canModerate(c.room(), c.email)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return sendSystemMessage(c, "Only moderators can change the topic")
+	}
+
+	if !validator.MaxChars(args.Raw, 255) {
+		return sendSystemMessage(c, "Topics cannot be more than 255 characters long")
+	}
+
+	if err := app.roomModel.SetTopic(c.room(), args.Raw); err != nil {
+		return err
+	}
+
+	if err := app.roomUpdated(c.room()); err != nil {
+		return err
+	}
+
+	return app.announce(c.room(), fmt.Sprintf("%s changed the topic to: %s", c.username, args.Raw))
+}
+
+func (app *application) leaveCommand(args CommandArgs, c *Client) error {
+	member, err := app.chatroomModel.IsMember(c.room(), c.email)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return sendSystemMessage(c, "You are not in this chatroom")
+	}
+
+	if err := app.chatroomModel.Delete(c.room(), c.email); err != nil {
+		return err
+	}
+
+	if err := sendSystemMessage(c, fmt.Sprintf("Left Chatroom '%s'", c.room())); err != nil {
+		return err
+	}
+
+	if err := app.announce(c.room(), fmt.Sprintf("%s left the chatroom", c.username)); err != nil {
+		return err
+	}
+
+	return app.succeedOwner(c.room())
+}
+
+func (app *application) whoCommand(args CommandArgs, c *Client) error {
+	members, err := app.chatroomModel.GetMembers(c.room())
+	if err != nil {
+		return err
+	}
+
+	roles, err := app.chatroomModel.GetRoles(c.room())
+	if err != nil {
+		return err
+	}
+
+	if len(members) == 0 {
+		return sendSystemMessage(c, "Nobody is in this chatroom")
+	}
+
+	here := map[string]bool{}
+	app.wsManager.RLock()
+	for client := range app.wsManager.clients {
+		if client.room() == c.room() {
+			here[client.email] = true
+		}
+	}
+	app.wsManager.RUnlock()
+
+	lines := []string{fmt.Sprintf("Members of %s:", c.room())}
+	for _, member := range members {
+		line := fmt.Sprintf("%s (%s)", member.UserName, member.Email)
+		if role := roles[member.Email]; role == models.RoleOwner || role == models.RoleModerator {
+			line += " - " + role
+		}
+		if here[member.Email] {
+			line += " - here now"
+		}
+		lines = append(lines, line)
+	}
+
+	return sendSystemMessage(c, strings.Join(lines, "\n"))
+}
+
+func (app *application) helpCommand(args CommandArgs, c *Client) error {
+	lines := []string{"Commands:"}
+	for _, cmd := range app.wsManager.allCommands() {
+		lines = append(lines, fmt.Sprintf("%s - %s", cmd.Usage, cmd.Description))
+	}
+	lines = append(lines, "Start a message with // to send it as text")
+
+	return sendSystemMessage(c, strings.Join(lines, "\n"))
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -22,6 +23,11 @@ type Client struct {
 	connection *websocket.Conn
 	manager    *Manager
 
+	email    string
+	username string
+	// mu guards chatroom, which other goroutines change when the chatroom is
+	// renamed or the user is removed from it
+	mu       sync.Mutex
 	chatroom string
 	// egress is used to avoid concurrent writes on the websocket connection
 	egress chan Event
@@ -31,6 +37,8 @@ func (app *application) NewClient(r *http.Request, conn *websocket.Conn, manager
 	return &Client{
 		connection: conn,
 		manager:    manager,
+		email:      app.sessionManager.GetString(r.Context(), "email"),
+		username:   app.sessionManager.GetString(r.Context(), "username"),
 		chatroom:   app.sessionManager.GetString(r.Context(), "chatroom"),
 		egress:     make(chan Event),
 	}
@@ -120,3 +128,28 @@ func (c *Client) pongHandler(pongMsg string) error {
 	log.Println("pong")
 	return c.connection.SetReadDeadline(time.Now().Add(pongWait))
 }
+
+// room returns the chatroom the client is in
+func (c *Client) room() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.chatroom
+}
+
+func (c *Client) setRoom(chatroom string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chatroom = chatroom
+}
+
+// moveRoom moves the client to chatroom to if it is still in from
+func (c *Client) moveRoom(from, to string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.chatroom == from {
+		c.chatroom = to
+	}
+}
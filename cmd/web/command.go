@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// CommandHandler runs a slash command sent by the client
+type CommandHandler func(args CommandArgs, c *Client) error
+
+type Command struct {
+	// Name is what follows the slash, e.g. "topic" for /topic
+	Name        string
+	Usage       string
+	Description string
+	Handler     CommandHandler
+}
+
+// CommandArgs is what was typed after the command name. Raw keeps the text
+// as typed and Args splits it into words, keeping "quoted text" together.
+type CommandArgs struct {
+	Raw  string
+	Args []string
+}
+
+// RegisterCommand makes a slash command available in every chatroom
+func (m *Manager) RegisterCommand(cmd Command) error {
+	name := strings.ToLower(strings.TrimPrefix(cmd.Name, "/"))
+	if name == "" || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid command name %q", cmd.Name)
+	}
+	if cmd.Handler == nil {
+		return fmt.Errorf("command /%s has no handler", name)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.commands[name]; ok {
+		return fmt.Errorf("command /%s is already registered", name)
+	}
+
+	cmd.Name = name
+	m.commands[name] = cmd
+
+	return nil
+}
+
+func (m *Manager) command(name string) (Command, bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	cmd, ok := m.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// allCommands returns the registered commands sorted by name
+func (m *Manager) allCommands() []Command {
+	m.RLock()
+	defer m.RUnlock()
+
+	cmds := []Command{}
+	for _, cmd := range m.commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+
+	return cmds
+}
+
+// parseCommand splits a message like "/topic Release day" into the command
+// name and its arguments. Messages starting with "//" are not commands.
+func parseCommand(message string) (string, CommandArgs, bool) {
+	if !strings.HasPrefix(message, "/") || strings.HasPrefix(message, "//") {
+		return "", CommandArgs{}, false
+	}
+
+	name, raw, _ := strings.Cut(message[1:], " ")
+	if name == "" {
+		return "", CommandArgs{}, false
+	}
+	raw = strings.TrimSpace(raw)
+
+	return name, CommandArgs{Raw: raw, Args: splitArgs(raw)}, true
+}
+
+// splitArgs splits on whitespace, keeping words inside double quotes together
+func splitArgs(raw string) []string {
+	args := []string{}
+	var current strings.Builder
+	quoted, started := false, false
+
+	for _, r := range raw {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case unicode.IsSpace(r) && !quoted:
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+
+	if started {
+		args = append(args, current.String())
+	}
+
+	return args
+}
+
+func (app *application) runCommand(name string, args CommandArgs, c *Client) error {
+	cmd, ok := app.wsManager.command(name)
+	if !ok {
+		return sendSystemMessage(c, fmt.Sprintf("Unknown command /%s, type /help to see the commands you can use", name))
+	}
+
+	err := cmd.Handler(args, c)
+	if errors.Is(err, errCommandUsage) {
+		return sendSystemMessage(c, "usage: "+cmd.Usage)
+	}
+
+	return err
+}
+
+// errCommandUsage is returned by command handlers to show the caller the command's usage
+var errCommandUsage = errors.New("command used incorrectly")
+
+// sendSystemMessage sends a message only the client's own socket will see
+func sendSystemMessage(c *Client, message string) error {
+	data, err := json.Marshal(SystemMessageEvent{Message: message, Chatroom: c.room(), Sent: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal system message: %v", err)
+	}
+
+	c.egress <- Event{Type: EventSystemMessage, Payload: data}
+
+	return nil
+}
+
+// sendError tells the client's own socket that what it asked for was refused
+func sendError(c *Client, errorEvent ErrorEvent) error {
+	data, err := json.Marshal(errorEvent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal error: %v", err)
+	}
+
+	c.egress <- Event{Type: EventError, Payload: data}
+
+	return nil
+}
+
+// announce sends a system message to everyone currently in the chatroom
+func (app *application) announce(chatroom, message string) error {
+	data, err := json.Marshal(SystemMessageEvent{Message: message, Chatroom: chatroom, Sent: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal system message: %v", err)
+	}
+
+	app.wsManager.broadcast(chatroom, Event{Type: EventSystemMessage, Payload: data})
+
+	return nil
+}
+
+// notifyUser sends a system message to every socket the user has open
+func (app *application) notifyUser(email, message string) {
+	data, err := json.Marshal(SystemMessageEvent{Message: message, Sent: time.Now()})
+	if err != nil {
+		app.logger.Error("failed to marshal system message", "error", err)
+		return
+	}
+
+	app.wsManager.sendToUser(email, Event{Type: EventSystemMessage, Payload: data})
+}
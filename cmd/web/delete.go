@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gochat.ayonchakroborty.net/internal/models"
+)
+
+// canDelete reports whether the user may delete the message, its sender
+// may and moderators may delete anyone's unless the chatroom is archived
+func (app *application) canDelete(chat *models.Chat, email string) (bool, error) {
+	archived, err := app.isArchived(chat.Chatroom)
+	if err != nil || archived {
+		return false, err
+	}
+
+	if chat.Sender == email {
+		return true, nil
+	}
+
+	return app.canModerate(chat.Chatroom, email)
+}
+
+func (app *application) DeleteMessage(event Event, c *Client) error {
+	var deleteEvent DeleteMessageEvent
+
+	if err := json.Unmarshal(event.Payload, &deleteEvent); err != nil {
+		return fmt.Errorf("bad payload in request: %v", err)
+	}
+
+	chat, err := app.chatModel.GetByID(deleteEvent.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return sendSystemMessage(c, fmt.Sprintf("Message %d does not exist", deleteEvent.ID))
+		}
+		return err
+	}
+
+	allowed, err := app.canDelete(chat, c.email)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return sendSystemMessage(c, "You cannot delete this message")
+	}
+
+	if err := app.chatModel.Delete(chat.ID, c.email); err != nil && !app.notIndexed(err) {
+		switch {
+		case errors.Is(err, models.ErrLegalHold):
+			return sendSystemMessage(c, "This message is under a legal hold and cannot be deleted")
+		case errors.Is(err, models.ErrNoRecord):
+			return nil
+		default:
+			return fmt.Errorf("failed to delete message: %v", err)
+		}
+	}
+
+	data, err := json.Marshal(MessageExpiredEvent{Chatroom: chat.Chatroom, IDs: []int{chat.ID}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal deleted message: %v", err)
+	}
+
+	app.wsManager.broadcast(chat.Chatroom, Event{Type: EventMessageDeleted, Payload: data})
+
+	return nil
+}
@@ -13,9 +13,29 @@ type Event struct {
 type EventHandler func(event Event, c *Client) error
 
 const (
-	EventSendMessage    = "send_message"
-	EventNewMessage     = "new_message"
-	EventChangeChatRoom = "change_room"
+	EventSendMessage     = "send_message"
+	EventNewMessage      = "new_message"
+	EventChangeChatRoom  = "change_room"
+	EventMention         = "mention"
+	EventKeywordAlert    = "keyword_alert"
+	EventPinMessage      = "pin_message"
+	EventUnpinMessage    = "unpin_message"
+	EventMessagePinned   = "message_pinned"
+	EventMessageUnpinned = "message_unpinned"
+	EventForwardMessage  = "forward_message"
+	EventSystemMessage   = "system_message"
+	EventCreatePoll      = "create_poll"
+	EventVotePoll        = "vote_poll"
+	EventClosePoll       = "close_poll"
+	EventPollUpdated     = "poll_updated"
+	EventMessageExpired  = "message_expired"
+	EventDeleteMessage   = "delete_message"
+	EventMessageDeleted  = "message_deleted"
+	EventJoinAnswered    = "join_request_answered"
+	EventModerated       = "moderated"
+	EventRoomUpdated     = "room_updated"
+	EventRoomRenamed     = "room_renamed"
+	EventError           = "error"
 )
 
 type SendMessageEvent struct {
@@ -27,9 +47,148 @@ type SendMessageEvent struct {
 
 type NewMessageEvent struct {
 	SendMessageEvent
-	Sent time.Time `json:"sent"`
+	ID        int             `json:"id"`
+	Sent      time.Time       `json:"sent"`
+	Forwarded *ForwardedEvent `json:"forwarded,omitempty"`
+	Poll      *PollEvent      `json:"poll,omitempty"`
+}
+
+// ForwardedEvent describes where a forwarded message was originally posted
+type ForwardedEvent struct {
+	Chatroom string    `json:"chatroom"`
+	From     string    `json:"from"`
+	Sent     time.Time `json:"sent"`
+}
+
+type MentionEvent struct {
+	NewMessageEvent
+	Mentioned string `json:"mentioned"`
 }
 
 type ChangeRoomEvent struct {
 	Name string `json:"name"`
 }
+
+type KeywordAlertEvent struct {
+	NewMessageEvent
+	Keyword string `json:"keyword"`
+}
+
+type PinMessageEvent struct {
+	ID int `json:"id"`
+}
+
+type MessagePinnedEvent struct {
+	ID       int    `json:"id"`
+	Chatroom string `json:"chatroom"`
+	From     string `json:"from"`
+	Message  string `json:"message"`
+	PinnedBy string `json:"pinned_by"`
+}
+
+type ForwardMessageEvent struct {
+	ID       int    `json:"id"`
+	Chatroom string `json:"chatroom"`
+}
+
+// SystemMessageEvent is a message from the server meant only for the user receiving it
+type SystemMessageEvent struct {
+	Message  string    `json:"message"`
+	Chatroom string    `json:"chatroom"`
+	Sent     time.Time `json:"sent"`
+}
+
+// ErrorEvent tells a client something it sent was refused, Code lets it tell
+// the reasons apart
+type ErrorEvent struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Chatroom string `json:"chatroom,omitempty"`
+	// RetryAfter is how many seconds to wait before trying again, if waiting helps
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+type CreatePollEvent struct {
+	Question  string     `json:"question"`
+	Options   []string   `json:"options"`
+	Multiple  bool       `json:"multiple"`
+	Anonymous bool       `json:"anonymous"`
+	ClosesAt  *time.Time `json:"closes_at"`
+}
+
+// VotePollEvent replaces the voter's choices in a poll, options are numbered from 1
+type VotePollEvent struct {
+	PollID  int   `json:"poll_id"`
+	Options []int `json:"options"`
+}
+
+type ClosePollEvent struct {
+	PollID int `json:"poll_id"`
+}
+
+type PollEvent struct {
+	ID        int               `json:"id"`
+	ChatID    int               `json:"chat_id"`
+	Chatroom  string            `json:"chatroom"`
+	Question  string            `json:"question"`
+	Multiple  bool              `json:"multiple"`
+	Anonymous bool              `json:"anonymous"`
+	ClosesAt  *time.Time        `json:"closes_at,omitempty"`
+	Closed    bool              `json:"closed"`
+	Options   []PollOptionEvent `json:"options"`
+}
+
+type PollOptionEvent struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+	Votes  int    `json:"votes"`
+	// Voters is left empty for anonymous polls
+	Voters []string `json:"voters,omitempty"`
+}
+
+// MessageExpiredEvent tells clients to drop messages that have been deleted
+type MessageExpiredEvent struct {
+	Chatroom string `json:"chatroom"`
+	IDs      []int  `json:"ids"`
+}
+
+type DeleteMessageEvent struct {
+	ID int `json:"id"`
+}
+
+// JoinAnsweredEvent tells someone who knocked whether they were let in
+type JoinAnsweredEvent struct {
+	Chatroom string `json:"chatroom"`
+	Approved bool   `json:"approved"`
+	By       string `json:"by"`
+}
+
+// ModeratedEvent tells a user they were kicked, banned or muted, or that a ban
+// or mute was lifted
+type ModeratedEvent struct {
+	Chatroom string     `json:"chatroom"`
+	Action   string     `json:"action"`
+	By       string     `json:"by"`
+	Reason   string     `json:"reason,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// RoomUpdatedEvent carries a chatroom's details after they change
+type RoomUpdatedEvent struct {
+	Chatroom    string `json:"chatroom"`
+	Topic       string `json:"topic"`
+	Description string `json:"description"`
+	// AvatarURL is empty when the room has no avatar
+	AvatarURL string `json:"avatar_url"`
+	Archived  bool   `json:"archived"`
+	// Announcement chatrooms only let moderators post
+	Announcement bool `json:"announcement"`
+	// SlowMode is the seconds members must leave between messages, zero when off
+	SlowMode int `json:"slow_mode"`
+}
+
+// RoomRenamedEvent tells members a chatroom has moved to a new name
+type RoomRenamedEvent struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
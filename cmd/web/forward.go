@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gochat.ayonchakroborty.net/internal/models"
+)
+
+func (app *application) ForwardMessage(event Event, c *Client) error {
+	var forwardEvent ForwardMessageEvent
+
+	if err := json.Unmarshal(event.Payload, &forwardEvent); err != nil {
+		return fmt.Errorf("bad payload in request: %v", err)
+	}
+	forwardEvent.Chatroom = strings.TrimSpace(forwardEvent.Chatroom)
+
+	source, err := app.chatModel.GetByID(forwardEvent.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return fmt.Errorf("message %d does not exist", forwardEvent.ID)
+		}
+		return err
+	}
+
+	canRead, err := app.chatroomModel.IsMember(source.Chatroom, c.email)
+	if err != nil {
+		return err
+	}
+	if !canRead {
+		return fmt.Errorf("%s may not read %s", c.email, source.Chatroom)
+	}
+
+	allowed, err := app.checkPost(c, forwardEvent.Chatroom)
+	if err != nil || !allowed {
+		return err
+	}
+
+	id, err := app.chatModel.InsertForward(forwardEvent.Chatroom, c.email, c.username, source)
+	if err != nil && !app.notIndexed(err) {
+		return fmt.Errorf("failed to save forwarded message : %v", err)
+	}
+
+	forwarded, err := app.chatModel.GetByID(id)
+	if err != nil {
+		return err
+	}
+
+	var broadMessage NewMessageEvent
+
+	broadMessage.ID = forwarded.ID
+	broadMessage.Sent = time.Now()
+	broadMessage.Message = forwarded.Message
+	broadMessage.From = forwarded.Username
+	broadMessage.Email = forwarded.Sender
+	broadMessage.Chatroom = forwarded.Chatroom
+	broadMessage.Forwarded = &ForwardedEvent{
+		Chatroom: forwarded.ForwardedFrom,
+		From:     forwarded.ForwardedAuthor,
+		Sent:     forwarded.ForwardedCreated,
+	}
+
+	data, err := json.Marshal(broadMessage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast message : %v", err)
+	}
+
+	app.wsManager.broadcast(forwarded.Chatroom, Event{Type: EventNewMessage, Payload: data})
+
+	return nil
+}
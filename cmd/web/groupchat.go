@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gochat.ayonchakroborty.net/internal/models"
+	"gochat.ayonchakroborty.net/internal/validator"
+)
+
+// maxGroupChat is how many users can be in a group chat, including whoever started it
+const maxGroupChat = 20
+
+// parseGroupChat splits a comma separated list of emails into everyone other
+// than email, saying what is wrong with the list if it cannot be used
+func parseGroupChat(list, email string) ([]string, string) {
+	seen := map[string]bool{email: true}
+	others := []string{}
+
+	for _, other := range strings.Split(list, ",") {
+		other = strings.TrimSpace(other)
+		if other == "" || seen[other] {
+			continue
+		}
+		if !validator.Matches(other, validator.EmailRX) {
+			return nil, fmt.Sprintf("'%s' is not an email address", other)
+		}
+		seen[other] = true
+		others = append(others, other)
+	}
+
+	if len(others)+1 > maxGroupChat {
+		return nil, fmt.Sprintf("Group chats can have at most %d people", maxGroupChat)
+	}
+
+	return others, ""
+}
+
+// openGroupChat takes email to the group chat between them and others,
+// starting one if they do not already have one
+func (app *application) openGroupChat(w http.ResponseWriter, r *http.Request, email string, others []string) {
+	members := append([]string{email}, others...)
+	sort.Strings(members)
+
+	name, err := app.chatroomModel.FindGroupChat(members)
+	if errors.Is(err, models.ErrNoRecord) {
+		// privacy settings only stop new group chats
+		for _, other := range others {
+			accepts, err := app.acceptsMessages(email, other)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if !accepts {
+				app.sessionManager.Put(r.Context(), "flash", dmRefused(other))
+				http.Redirect(w, r, "/chat", http.StatusSeeOther)
+				return
+			}
+		}
+
+		// returns the existing group chat if someone started it since FindGroupChat
+		name, err = app.chatroomModel.InsertGroupChat(members)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "chatroom", name)
+
+	http.Redirect(w, r, "/chat", http.StatusSeeOther)
+}
+
+// groupChat checks c is in a group chat, telling them why not if they are not
+func (app *application) groupChat(c *Client) (bool, error) {
+	member, err := app.chatroomModel.IsMember(c.room(), c.email)
+	if err != nil {
+		return false, err
+	}
+	if !member || !models.IsGroupChat(c.room()) {
+		return false, sendSystemMessage(c, "Only group chats you are in can be changed this way")
+	}
+
+	return true, nil
+}
+
+// addCommand brings another user into a group chat, history included
+func (app *application) addCommand(args CommandArgs, c *Client) error {
+	if len(args.Args) != 1 || !validator.Matches(args.Args[0], validator.EmailRX) {
+		return errCommandUsage
+	}
+	email := args.Args[0]
+
+	ok, err := app.groupChat(c)
+	if err != nil || !ok {
+		return err
+	}
+
+	members, err := app.chatroomModel.GetUsersList(c.room())
+	if err != nil {
+		return err
+	}
+	if len(members) >= maxGroupChat {
+		return sendSystemMessage(c, fmt.Sprintf("Group chats can have at most %d people", maxGroupChat))
+	}
+
+	member, err := app.chatroomModel.IsMember(c.room(), email)
+	if err != nil {
+		return err
+	}
+	if member {
+		return sendSystemMessage(c, fmt.Sprintf("%s is already in this group chat", email))
+	}
+
+	accepts, err := app.acceptsMessages(c.email, email)
+	if err != nil {
+		return err
+	}
+	if !accepts {
+		return sendSystemMessage(c, dmRefused(email))
+	}
+
+	if err := app.chatroomModel.Insert(c.room(), email, true, models.RoleMember); err != nil {
+		return err
+	}
+
+	app.notifyUser(email, fmt.Sprintf("%s added you to a group chat", c.username))
+
+	username, err := app.userModel.GetUserField("username", email)
+	if err != nil {
+		return err
+	}
+
+	return app.announce(c.room(), fmt.Sprintf("%s added %s to the group chat", c.username, username))
+}
+
+// convertCommand turns a group chat into a named invite only chatroom owned by
+// whoever converts it, keeping its members and history
+func (app *application) convertCommand(args CommandArgs, c *Client) error {
+	name := strings.TrimSpace(args.Raw)
+	if name == "" {
+		return errCommandUsage
+	}
+
+	ok, err := app.groupChat(c)
+	if err != nil || !ok {
+		return err
+	}
+
+	if problem := roomNameProblem(name, c.room()); problem != "" {
+		return sendSystemMessage(c, problem)
+	}
+
+	from := c.room()
+
+	if err := app.chatroomModel.ConvertGroupChat(from, name, c.email); err != nil {
+		if errors.Is(err, models.ErrDuplicateChatroom) {
+			return sendSystemMessage(c, fmt.Sprintf("There is already a chatroom called %s", name))
+		}
+		return err
+	}
+
+	if err := app.roomMoved(from, name); err != nil {
+		return err
+	}
+
+	return app.announce(name, fmt.Sprintf("%s turned the group chat into the chatroom %s, which they own", c.username, name))
+}
@@ -3,13 +3,18 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"gochat.ayonchakroborty.net/internal/models"
+	"gochat.ayonchakroborty.net/internal/search"
 	"gochat.ayonchakroborty.net/internal/validator"
 )
 
@@ -24,8 +29,10 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
 	publicChatrooms := []*models.Chatroom{}
 	privateChatrooms := []*models.Chatroom{}
+	names := []string{}
 
 	for _, cr := range chatrooms {
+		names = append(names, cr.Name)
 		if cr.Private {
 			privateChatrooms = append(privateChatrooms, cr)
 		} else {
@@ -33,7 +40,26 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	data.PublicChatrooms = publicChatrooms
+	data.Rooms, err = app.roomModel.GetMany(names)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// archived rooms are listed apart from the active ones
+	activeChatrooms := []*models.Chatroom{}
+	archivedChatrooms := []*models.Chatroom{}
+
+	for _, cr := range publicChatrooms {
+		if data.Rooms[cr.Name].Archived.IsZero() {
+			activeChatrooms = append(activeChatrooms, cr)
+		} else {
+			archivedChatrooms = append(archivedChatrooms, cr)
+		}
+	}
+
+	data.PublicChatrooms = activeChatrooms
+	data.ArchivedChatrooms = archivedChatrooms
 	data.PrivateChatrooms = privateChatrooms
 
 	for _, room := range data.PublicChatrooms {
@@ -125,12 +151,30 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.chatroomModel.Insert("general", form.Email, false)
+	err = app.chatroomModel.Insert("general", form.Email, false, models.RoleMember)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	autoJoin, err := app.roomModel.GetAutoJoin()
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	for _, name := range autoJoin {
+		if name == "general" {
+			continue
+		}
+
+		err = app.chatroomModel.Insert(name, form.Email, false, models.RoleMember)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
 	app.sessionManager.Put(r.Context(), "flash", "Account created successfully!")
 
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -228,6 +272,21 @@ func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) chat(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
+
+	if data.Chatroom != "" {
+		allowed, err := app.canRead(data.Chatroom, data.Email)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !allowed {
+			app.sessionManager.Remove(r.Context(), "chatroom")
+			data.Flash = fmt.Sprintf("You are not in '%s'", data.Chatroom)
+			data.Chatroom = ""
+		}
+	}
+
 	chats, err := app.chatModel.Get(data.Chatroom)
 	if err != nil {
 		app.serverError(w, r, err)
@@ -244,7 +303,36 @@ func (app *application) chat(w http.ResponseWriter, r *http.Request) {
 		chat.Created = chat.Created.In(loc)
 	}
 
+	chatIDs := []int{}
+	for _, chat := range chats {
+		chatIDs = append(chatIDs, chat.ID)
+	}
+
+	polls, err := app.pollModel.GetForChats(chatIDs)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	for _, chat := range chats {
+		chat.Poll = polls[chat.ID]
+	}
+
+	pins, err := app.pinModel.GetAll(data.Chatroom)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	room, err := app.roomModel.Get(data.Chatroom)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	data.Chats = chats
+	data.Pins = pins
+	data.Room = room
 	app.render(w, r, http.StatusOK, "chat.html", data)
 }
 
@@ -333,6 +421,7 @@ func (app *application) userAccountPost(w http.ResponseWriter, r *http.Request)
 
 type chatRoomForm struct {
 	Chatroom            string `form:"chatroom"`
+	InviteOnly          bool   `form:"invite_only"`
 	validator.Validator `form:"-"`
 }
 
@@ -343,6 +432,87 @@ func (app *application) chatRoom(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/chat", http.StatusSeeOther)
 }
 
+func (app *application) chatRoomPins(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	name := r.PathValue("name")
+
+	member, err := app.chatroomModel.IsMember(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !member {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	pins, err := app.pinModel.GetAll(name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Chatroom = name
+	data.Pins = pins
+	app.render(w, r, http.StatusOK, "pins.html", data)
+}
+
+// exportBatchSize is how many messages are read at a time when exporting a chatroom
+const exportBatchSize = 1000
+
+// chatRoomExport downloads the chatroom's whole history as a text transcript,
+// archived chatrooms included
+func (app *application) chatRoomExport(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	name := r.PathValue("name")
+
+	member, err := app.chatroomModel.IsMember(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !member {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	chats, err := app.chatModel.GetRoomBatchAfter(name, 0, exportBatchSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".txt"}))
+
+	fmt.Fprintf(w, "%s, exported %s\n\n", name, time.Now().In(loc).Format("Mon 1/2/2006 3:04 PM"))
+
+	// the transcript is written a batch at a time so long histories are not
+	// held in memory, an error part way can only be logged
+	for len(chats) > 0 {
+		for _, chat := range chats {
+			fmt.Fprintf(w, "[%s] %s: %s\n", chat.Created.In(loc).Format("Mon 1/2/2006 3:04 PM"), chat.Username, chat.Message)
+		}
+
+		if len(chats) < exportBatchSize {
+			break
+		}
+
+		chats, err = app.chatModel.GetRoomBatchAfter(name, chats[len(chats)-1].ID, exportBatchSize)
+		if err != nil {
+			app.logger.Error("failed to export chatroom", "chatroom", name, "error", err)
+			return
+		}
+	}
+}
+
 func (app *application) chatRoomPost(w http.ResponseWriter, r *http.Request) {
 	email := app.sessionManager.GetString(r.Context(), "email")
 
@@ -363,23 +533,37 @@ func (app *application) chatRoomPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cr := form.Chatroom
-	private := false
-	if validator.Matches(form.Chatroom, validator.EmailRX) {
-		if strings.Compare(form.Chatroom, email) == 0 {
+	// a comma separated list of emails opens a group chat
+	if strings.Contains(form.Chatroom, ",") {
+		others, problem := parseGroupChat(form.Chatroom, email)
+		if problem != "" {
+			app.sessionManager.Put(r.Context(), "flash", problem)
 			http.Redirect(w, r, "/chat", http.StatusSeeOther)
 			return
 		}
 
-		exists, err := app.userModel.EmailExists(form.Chatroom)
-		if err != nil {
-			app.serverError(w, r, err)
+		switch len(others) {
+		case 0:
+			http.Redirect(w, r, "/chat", http.StatusSeeOther)
+			return
+		case 1:
+			form.Chatroom = others[0]
+		default:
+			app.openGroupChat(w, r, email, others)
 			return
 		}
+	}
+
+	if models.IsGroupChat(form.Chatroom) {
+		app.sessionManager.Put(r.Context(), "flash", "That name is kept for private chatrooms")
+		http.Redirect(w, r, "/chat", http.StatusSeeOther)
+		return
+	}
 
-		if !exists {
-			flash := fmt.Sprintf("User '%s' does not exist", form.Chatroom)
-			app.sessionManager.Put(r.Context(), "flash", flash)
+	cr := form.Chatroom
+	private := false
+	if validator.Matches(form.Chatroom, validator.EmailRX) {
+		if strings.Compare(form.Chatroom, email) == 0 {
 			http.Redirect(w, r, "/chat", http.StatusSeeOther)
 			return
 		}
@@ -388,23 +572,95 @@ func (app *application) chatRoomPost(w http.ResponseWriter, r *http.Request) {
 		sort.Strings(sorted)
 		cr = "Private chatroom for " + sorted[0] + " and " + sorted[1]
 		private = true
+
+		// privacy settings only stop new private chatrooms
+		member, err := app.chatroomModel.IsMember(cr, email)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !member {
+			accepts, err := app.acceptsMessages(email, form.Chatroom)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			if !accepts {
+				app.sessionManager.Put(r.Context(), "flash", dmRefused(form.Chatroom))
+				http.Redirect(w, r, "/chat", http.StatusSeeOther)
+				return
+			}
+		}
 	}
 
+	// whoever creates a group chatroom owns it
+	role := models.RoleMember
+
 	_, err := app.chatroomModel.Get(cr, email, private)
 	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) && !private {
+			exists, privateRoom, err := app.chatroomModel.Exists(cr)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			room, err := app.roomModel.Get(cr)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			ban, err := app.moderationModel.GetBan(cr, email)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if ban != nil {
+				app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You are banned from '%s'", cr))
+				http.Redirect(w, r, "/chat", http.StatusSeeOther)
+				return
+			}
+
+			if privateRoom || room.JoinPolicy == models.JoinInvite {
+				app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("'%s' is invite only", cr))
+				http.Redirect(w, r, "/chat", http.StatusSeeOther)
+				return
+			}
+
+			if room.JoinPolicy == models.JoinKnock {
+				http.Redirect(w, r, "/chat/room/"+url.PathEscape(cr)+"/knock", http.StatusSeeOther)
+				return
+			}
+
+			if !exists {
+				role = models.RoleOwner
+			}
+
+			// only whoever creates a room chooses whether it is invite only
+			if !exists && form.InviteOnly {
+				if err := app.roomModel.SetJoinPolicy(cr, models.JoinInvite); err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+			}
+		}
+
 		if errors.Is(err, models.ErrNoRecord) {
 			private := false
 			// if the chatroom is a user email then insert 2 times for each user
 			if validator.Matches(form.Chatroom, validator.EmailRX) {
 				private = true
-				err := app.chatroomModel.Insert(cr, form.Chatroom, private)
+				err := app.chatroomModel.Insert(cr, form.Chatroom, private, models.RoleMember)
 				if err != nil {
 					log.Print("Error while inserting new chat room", err)
 					http.Redirect(w, r, "/", http.StatusSeeOther)
 				}
 			}
 			// public chat room
-			err := app.chatroomModel.Insert(cr, email, private)
+			err := app.chatroomModel.Insert(cr, email, private, role)
 			if err != nil {
 				log.Print("Error while inserting new chat room", err)
 				http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -434,19 +690,52 @@ func (app *application) userDeletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := app.userModel.DeleteUser(email); err != nil {
+	chatrooms, err := app.chatroomModel.GetAllChats(email)
+	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	if err := app.userModel.DeleteUser(email); err != nil {
+		if errors.Is(err, models.ErrLegalHold) {
+			app.sessionManager.Put(r.Context(), "flash", "Your account is under a legal hold and cannot be deleted right now")
+			http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
 	for n := range names {
 		log.Println("name", n)		
-		if err := app.chatroomModel.DeletePrivateCR(n); err != nil{
+		// the others in a group chat keep it
+		if models.IsGroupChat(n) {
+			if err := app.chatroomModel.Delete(n, email); err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			continue
+		}
+		if err := app.chatroomModel.DeletePrivateCR(n, email); err != nil && !errors.Is(err, models.ErrLegalHold) {
 			app.serverError(w, r, err)
 			return
 		} 
 	}
 
+	// group chatrooms the user owned are handed on so they can still be managed
+	for _, cr := range chatrooms {
+		if cr.Private {
+			continue
+		}
+		if err := app.chatroomModel.Delete(cr.Name, email); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if err := app.succeedOwner(cr.Name); err != nil {
+			app.logger.Error("failed to hand on chatroom ownership", "chatroom", cr.Name, "error", err)
+		}
+	}
+
 	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
 	app.sessionManager.Remove(r.Context(), "email")
 	app.sessionManager.Remove(r.Context(), "username")
@@ -460,72 +749,273 @@ type searchForm struct {
 	validator.Validator
 }
 
-func (app *application) chatSearch(w http.ResponseWriter, r *http.Request) {
-	app.render(w, r, http.StatusSeeOther, "search.html", app.newTemplateData(r))
+// searchPageSize is how many message search results are shown per page
+const searchPageSize = 20
+
+type messageSearchForm struct {
+	Query               string `form:"q"`
+	Chatroom            string `form:"room"`
+	Sender              string `form:"sender"`
+	From                string `form:"from"`
+	To                  string `form:"to"`
+	Page                int    `form:"page"`
+	HasMore             bool   `form:"-"`
+	validator.Validator `form:"-"`
 }
 
-func (app *application) chatSearchPost(w http.ResponseWriter, r *http.Request) {
-	email := app.sessionManager.GetString(r.Context(), "email")
+// query validates the form and converts it into a search query, dates are
+// entered in the chat's timezone and To includes the whole day
+func (form *messageSearchForm) query() search.Query {
+	filter := search.Query{
+		Text:     form.Query,
+		Chatroom: form.Chatroom,
+		Sender:   form.Sender,
+		Limit:    searchPageSize + 1,
+		Offset:   (form.Page - 1) * searchPageSize,
+	}
 
-	if err := r.ParseForm(); err != nil {
-		app.clientError(w, http.StatusUnprocessableEntity)
-		return
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		loc = time.UTC
 	}
 
-	form := searchForm{}
-	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
-		app.clientError(w, http.StatusUnprocessableEntity)
-		return
+	if form.From != "" {
+		from, err := time.ParseInLocation("2006-01-02", form.From, loc)
+		form.CheckField(err == nil, "from", "This field must be a valid date")
+		filter.From = from
 	}
-	form.Search = strings.TrimSpace(form.Search)
 
-	if !validator.NotBlank(form.Search) {
-		http.Redirect(w, r, "/chat/search", http.StatusSeeOther)
-		return
+	if form.To != "" {
+		to, err := time.ParseInLocation("2006-01-02", form.To, loc)
+		form.CheckField(err == nil, "to", "This field must be a valid date")
+		filter.To = to.AddDate(0, 0, 1)
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() {
+		form.CheckField(filter.From.Before(filter.To), "to", "The end date cannot be before the start date")
 	}
 
+	return filter
+}
+
+// PageURL links to the given page of the same search
+func (form messageSearchForm) PageURL(page int) string {
+	values := url.Values{}
+	values.Set("q", form.Query)
+	values.Set("room", form.Chatroom)
+	values.Set("sender", form.Sender)
+	values.Set("from", form.From)
+	values.Set("to", form.To)
+	values.Set("page", strconv.Itoa(page))
+
+	return "/chat/search?" + values.Encode()
+}
+
+func (form messageSearchForm) PrevURL() string {
+	return form.PageURL(form.Page - 1)
+}
+
+func (form messageSearchForm) NextURL() string {
+	return form.PageURL(form.Page + 1)
+}
+
+// chatSearch searches message text when the page is loaded with a query,
+// results only come from chatrooms the user is in
+func (app *application) chatSearch(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	chatrooms := []*models.Chatroom{}
-	var err error
 
-	if validator.Matches(form.Search, validator.EmailRX) {
-		chatrooms, err = app.chatroomModel.SearchUser(email, form.Search)
-	} else {
-		chatrooms, err = app.chatroomModel.GetSearchedChat(email, form.Search)
+	form := messageSearchForm{}
+	if err := app.formDecoder.Decode(&form, r.URL.Query()); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Query = strings.TrimSpace(form.Query)
+	form.Chatroom = strings.TrimSpace(form.Chatroom)
+	form.Sender = strings.TrimSpace(form.Sender)
+	form.Page = max(form.Page, 1)
+
+	if form.Query == "" {
+		data.Form = form
+		app.render(w, r, http.StatusOK, "search.html", data)
+		return
+	}
+
+	query := form.query()
+	if !form.Valid() {
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "search.html", data)
+		return
 	}
 
+	chatrooms, err := app.chatroomModel.GetAllChats(data.Email)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	publicChatrooms := []*models.Chatroom{}
-	privateChatrooms := []*models.Chatroom{}
-
 	for _, cr := range chatrooms {
-		if cr.Private {
-			privateChatrooms = append(privateChatrooms, cr)
-		} else {
-			publicChatrooms = append(publicChatrooms, cr)
-		}
+		query.Chatrooms = append(query.Chatrooms, cr.Name)
 	}
 
-	data.PublicChatrooms = publicChatrooms
-	data.PrivateChatrooms = privateChatrooms
+	hits, err := app.searchIndex.Search(query)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
-	for _, room := range data.PublicChatrooms {
-		names, err := app.chatroomModel.GetUsersInChatroom(room.Name, room.Private)
-		if err != nil {
-			app.serverError(w, r, err)
-			return
-		}
+	if len(hits) > searchPageSize {
+		hits = hits[:searchPageSize]
+		form.HasMore = true
+	}
 
-		formattedString := strings.Join(names, ", ")
-		room.AllUsers = formattedString
+	ids := []int{}
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
 	}
 
-	for _, room := range data.PrivateChatrooms {
-		names, err := app.chatroomModel.GetUsersInChatroom(room.Name, room.Private)
+	chats, err := app.chatModel.GetByIDs(ids)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// the index can briefly hold messages that are already gone, those are skipped
+	results := []*models.SearchResult{}
+	for _, hit := range hits {
+		chat, ok := chats[hit.ID]
+		if !ok {
+			continue
+		}
+		chat.Created = chat.Created.In(loc)
+		results = append(results, &models.SearchResult{Chat: *chat, Score: hit.Score})
+	}
+
+	data.Form = form
+	data.SearchResults = results
+	app.render(w, r, http.StatusOK, "search.html", data)
+}
+
+// chatMessage shows a message along with the messages sent around it
+func (app *application) chatMessage(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	chat, err := app.chatModel.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	member, err := app.chatroomModel.IsMember(chat.Chatroom, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !member {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	chats, err := app.chatModel.GetContext(chat, 10)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	for _, c := range chats {
+		c.Created = c.Created.In(loc)
+	}
+
+	data := app.newTemplateData(r)
+	data.Chat = chat
+	data.Chats = chats
+	app.render(w, r, http.StatusOK, "message.html", data)
+}
+
+func (app *application) chatSearchPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusUnprocessableEntity)
+		return
+	}
+
+	form := searchForm{}
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	form.Search = strings.TrimSpace(form.Search)
+
+	if !validator.NotBlank(form.Search) {
+		http.Redirect(w, r, "/chat/search", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = messageSearchForm{Page: 1}
+	chatrooms := []*models.Chatroom{}
+	var err error
+
+	if validator.Matches(form.Search, validator.EmailRX) {
+		chatrooms, err = app.chatroomModel.SearchUser(email, form.Search)
+	} else {
+		chatrooms, err = app.chatroomModel.GetSearchedChat(email, form.Search)
+	}
+
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	publicChatrooms := []*models.Chatroom{}
+	privateChatrooms := []*models.Chatroom{}
+
+	for _, cr := range chatrooms {
+		if cr.Private {
+			privateChatrooms = append(privateChatrooms, cr)
+		} else {
+			publicChatrooms = append(publicChatrooms, cr)
+		}
+	}
+
+	data.PublicChatrooms = publicChatrooms
+	data.PrivateChatrooms = privateChatrooms
+
+	for _, room := range data.PublicChatrooms {
+		names, err := app.chatroomModel.GetUsersInChatroom(room.Name, room.Private)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		formattedString := strings.Join(names, ", ")
+		room.AllUsers = formattedString
+	}
+
+	for _, room := range data.PrivateChatrooms {
+		names, err := app.chatroomModel.GetUsersInChatroom(room.Name, room.Private)
 		if err != nil {
 			app.serverError(w, r, err)
 			return
@@ -542,11 +1032,27 @@ func (app *application) chatLeavePost(w http.ResponseWriter, r *http.Request) {
 	email := app.sessionManager.GetString(r.Context(), "email")
 	chatroom := app.sessionManager.GetString(r.Context(), "chatroom")
 
+	member, err := app.chatroomModel.IsMember(chatroom, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !member {
+		app.sessionManager.Put(r.Context(), "flash", "You are not in this chatroom")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
 	if err := app.chatroomModel.Delete(chatroom, email); err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	if err := app.succeedOwner(chatroom); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	flash := fmt.Sprintf("Left Chatroom '%s'", chatroom)
 	app.sessionManager.Put(r.Context(), "flash", flash)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -566,3 +1072,1253 @@ func (app *application) usersList(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, http.StatusOK, "usersList.html", data)
 
 }
+
+func (app *application) userMentions(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+
+	mentions, err := app.mentionModel.GetRecent(data.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.Mentions = mentions
+	app.render(w, r, http.StatusOK, "mentions.html", data)
+}
+
+type keywordForm struct {
+	ID                  int    `form:"id"`
+	Keyword             string `form:"keyword"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userAlerts(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+
+	keywords, err := app.keywordModel.GetAll(data.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	alerts, err := app.alertModel.GetRecent(data.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.Form = keywordForm{}
+	data.Keywords = keywords
+	data.Alerts = alerts
+	app.render(w, r, http.StatusOK, "alerts.html", data)
+}
+
+func (app *application) userKeywordPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := keywordForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Keyword = strings.TrimSpace(form.Keyword)
+
+	form.CheckField(validator.NotBlank(form.Keyword), "keyword", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Keyword, 100), "keyword", "This field cannot be more than 100 characters long")
+
+	if form.Valid() {
+		err := app.keywordModel.Insert(email, form.Keyword)
+		if err == nil {
+			app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Alerting you about '%s'", form.Keyword))
+			http.Redirect(w, r, "/user/alerts", http.StatusSeeOther)
+			return
+		}
+
+		if !errors.Is(err, models.ErrDuplicateKeyword) {
+			app.serverError(w, r, err)
+			return
+		}
+		form.AddFieldError("keyword", "You are already subscribed to this keyword")
+	}
+
+	data := app.newTemplateData(r)
+
+	keywords, err := app.keywordModel.GetAll(email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	alerts, err := app.alertModel.GetRecent(email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.Form = form
+	data.Keywords = keywords
+	data.Alerts = alerts
+	app.render(w, r, http.StatusUnprocessableEntity, "alerts.html", data)
+}
+
+func (app *application) userKeywordDeletePost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := keywordForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.keywordModel.Delete(form.ID, email); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/alerts", http.StatusSeeOther)
+}
+
+type privacyForm struct {
+	DMPolicy            string `form:"dm_policy"`
+	Contact             string `form:"contact"`
+	ID                  int    `form:"id"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userPrivacy(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	policy, err := app.userModel.GetDMPolicy(email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.renderPrivacy(w, r, http.StatusOK, privacyForm{DMPolicy: policy})
+}
+
+func (app *application) renderPrivacy(w http.ResponseWriter, r *http.Request, status int, form privacyForm) {
+	data := app.newTemplateData(r)
+
+	contacts, err := app.contactModel.GetAll(data.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.Form = form
+	data.Contacts = contacts
+	app.render(w, r, status, "privacy.html", data)
+}
+
+func (app *application) userPrivacyPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := privacyForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !dmPolicies[form.DMPolicy] {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.userModel.SetDMPolicy(email, form.DMPolicy); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Privacy settings saved")
+	http.Redirect(w, r, "/user/privacy", http.StatusSeeOther)
+}
+
+func (app *application) userContactPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := privacyForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Contact = strings.TrimSpace(form.Contact)
+
+	form.CheckField(validator.NotBlank(form.Contact), "contact", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Contact, validator.EmailRX), "contact", "This field must be a valid email")
+	form.CheckField(form.Contact != email, "contact", "You cannot add yourself")
+
+	// contacts are saved whether or not they have an account, so this does not
+	// reveal who has signed up
+	if form.Valid() {
+		err := app.contactModel.Insert(email, form.Contact)
+		if err == nil {
+			app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Added '%s' to your contacts", form.Contact))
+			http.Redirect(w, r, "/user/privacy", http.StatusSeeOther)
+			return
+		}
+
+		if !errors.Is(err, models.ErrDuplicateContact) {
+			app.serverError(w, r, err)
+			return
+		}
+		form.AddFieldError("contact", "This email is already in your contacts")
+	}
+
+	policy, err := app.userModel.GetDMPolicy(email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	form.DMPolicy = policy
+
+	app.renderPrivacy(w, r, http.StatusUnprocessableEntity, form)
+}
+
+func (app *application) userContactDeletePost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := privacyForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.contactModel.Delete(form.ID, email); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/privacy", http.StatusSeeOther)
+}
+
+type bookmarkForm struct {
+	ID                  int    `form:"id"`
+	ChatID              int    `form:"chat_id"`
+	Note                string `form:"note"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userBookmarks(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+
+	bookmarks, err := app.bookmarkModel.GetAll(data.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.Bookmarks = bookmarks
+	app.render(w, r, http.StatusOK, "bookmarks.html", data)
+}
+
+func (app *application) userBookmarkPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := bookmarkForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Note = strings.TrimSpace(form.Note)
+
+	if !validator.MaxChars(form.Note, 255) {
+		app.sessionManager.Put(r.Context(), "flash", "Bookmark notes cannot be more than 255 characters long")
+		http.Redirect(w, r, "/chat", http.StatusSeeOther)
+		return
+	}
+
+	chat, err := app.chatModel.GetByID(form.ChatID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Message #%d does not exist", form.ChatID))
+			http.Redirect(w, r, "/chat", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	member, err := app.chatroomModel.IsMember(chat.Chatroom, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !member {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Message #%d does not exist", form.ChatID))
+		http.Redirect(w, r, "/chat", http.StatusSeeOther)
+		return
+	}
+
+	if err := app.bookmarkModel.Insert(email, chat.ID, form.Note); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Saved message #%d", chat.ID))
+	http.Redirect(w, r, "/chat", http.StatusSeeOther)
+}
+
+func (app *application) userBookmarkDeletePost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := bookmarkForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.bookmarkModel.Delete(form.ID, email); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/bookmarks", http.StatusSeeOther)
+}
+
+type scheduledMessageForm struct {
+	ID                  int    `form:"id"`
+	Chatroom            string `form:"chatroom"`
+	Message             string `form:"message"`
+	SendAt              string `form:"send_at"`
+	validator.Validator `form:"-"`
+}
+
+// checkSendAt validates the form's send time, entered in the same timezone the chat is shown in
+func (form *scheduledMessageForm) checkSendAt() time.Time {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		loc = time.UTC
+	}
+
+	sendAt, err := time.ParseInLocation("2006-01-02T15:04", form.SendAt, loc)
+	if err != nil {
+		form.AddFieldError("send_at", "The send time must be a valid date and time")
+		return sendAt
+	}
+
+	form.CheckField(sendAt.After(time.Now()), "send_at", "The send time must be in the future")
+
+	return sendAt
+}
+
+func (app *application) renderScheduled(w http.ResponseWriter, r *http.Request, status int, form scheduledMessageForm) {
+	data := app.newTemplateData(r)
+
+	messages, err := app.scheduledModel.GetPending(data.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.Form = form
+	data.ScheduledMessages = messages
+	app.render(w, r, status, "scheduled.html", data)
+}
+
+func (app *application) chatScheduled(w http.ResponseWriter, r *http.Request) {
+	form := scheduledMessageForm{
+		Chatroom: app.sessionManager.GetString(r.Context(), "chatroom"),
+	}
+
+	app.renderScheduled(w, r, http.StatusOK, form)
+}
+
+func (app *application) chatScheduledPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	username := app.sessionManager.GetString(r.Context(), "username")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := scheduledMessageForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Chatroom = strings.TrimSpace(form.Chatroom)
+
+	form.CheckField(validator.NotBlank(form.Chatroom), "chatroom", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.Message), "message", "This field cannot be blank")
+	sendAt := form.checkSendAt()
+
+	if form.Valid() {
+		allowed, err := app.canPost(form.Chatroom, email)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(allowed, "chatroom", "You cannot post in this chatroom")
+	}
+
+	if !form.Valid() {
+		app.renderScheduled(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	_, err := app.scheduledModel.Insert(form.Chatroom, email, username, form.Message, sendAt)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Message scheduled!")
+	http.Redirect(w, r, "/chat/scheduled", http.StatusSeeOther)
+}
+
+func (app *application) chatScheduledEditPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := scheduledMessageForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Message), "message", "The message cannot be blank")
+	sendAt := form.checkSendAt()
+
+	if !form.Valid() {
+		for _, msg := range form.FieldErrors {
+			app.sessionManager.Put(r.Context(), "flash", msg)
+		}
+		http.Redirect(w, r, "/chat/scheduled", http.StatusSeeOther)
+		return
+	}
+
+	if err := app.scheduledModel.Update(form.ID, email, form.Message, sendAt); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "That message has already been sent or cancelled")
+			http.Redirect(w, r, "/chat/scheduled", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Scheduled message updated!")
+	http.Redirect(w, r, "/chat/scheduled", http.StatusSeeOther)
+}
+
+func (app *application) chatScheduledCancelPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := scheduledMessageForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.scheduledModel.Delete(form.ID, email); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "That message has already been sent or cancelled")
+			http.Redirect(w, r, "/chat/scheduled", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Scheduled message cancelled")
+	http.Redirect(w, r, "/chat/scheduled", http.StatusSeeOther)
+}
+
+type holdForm struct {
+	ID                  int    `form:"id"`
+	Kind                string `form:"kind"`
+	Target              string `form:"target"`
+	Reason              string `form:"reason"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) renderHolds(w http.ResponseWriter, r *http.Request, status int, form holdForm) {
+	data := app.newTemplateData(r)
+
+	holds, err := app.holdModel.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	audits, err := app.holdModel.GetAudit()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.Form = form
+	data.Holds = holds
+	data.HoldAudits = audits
+	app.render(w, r, status, "holds.html", data)
+}
+
+func (app *application) adminHolds(w http.ResponseWriter, r *http.Request) {
+	app.renderHolds(w, r, http.StatusOK, holdForm{Kind: models.HoldUser})
+}
+
+func (app *application) adminHoldPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := holdForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Target = strings.TrimSpace(form.Target)
+	form.Reason = strings.TrimSpace(form.Reason)
+
+	form.CheckField(form.Kind == models.HoldUser || form.Kind == models.HoldRoom, "kind", "Holds apply to a user or a room")
+	form.CheckField(validator.NotBlank(form.Target), "target", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Target, 255), "target", "This field cannot be more than 255 characters long")
+	form.CheckField(validator.NotBlank(form.Reason), "reason", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Reason, 255), "reason", "This field cannot be more than 255 characters long")
+
+	if !form.Valid() {
+		app.renderHolds(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	if err := app.holdModel.Insert(form.Kind, form.Target, form.Reason, email); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Placed a hold on %s %s", form.Kind, form.Target))
+	http.Redirect(w, r, "/admin/holds", http.StatusSeeOther)
+}
+
+func (app *application) adminHoldDeletePost(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := holdForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.holdModel.Delete(form.ID); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Hold released")
+	http.Redirect(w, r, "/admin/holds", http.StatusSeeOther)
+}
+
+// directoryPageSize is how many rooms the directory shows per page
+const directoryPageSize = 25
+
+type directoryForm struct {
+	Query   string `form:"q"`
+	Sort    string `form:"sort"`
+	Page    int    `form:"page"`
+	HasMore bool   `form:"-"`
+}
+
+// PageURL links to the given page of the same listing
+func (form directoryForm) PageURL(page int) string {
+	values := url.Values{}
+	values.Set("q", form.Query)
+	values.Set("sort", form.Sort)
+	values.Set("page", strconv.Itoa(page))
+
+	return "/chat/directory?" + values.Encode()
+}
+
+func (form directoryForm) PrevURL() string {
+	return form.PageURL(form.Page - 1)
+}
+
+func (form directoryForm) NextURL() string {
+	return form.PageURL(form.Page + 1)
+}
+
+// chatDirectory lists the public chatrooms anyone can join
+func (app *application) chatDirectory(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+
+	form := directoryForm{}
+	if err := app.formDecoder.Decode(&form, r.URL.Query()); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Query = strings.TrimSpace(form.Query)
+	form.Page = max(form.Page, 1)
+	if form.Sort != models.DirectoryRecent {
+		form.Sort = models.DirectoryPopular
+	}
+
+	rooms, err := app.chatroomModel.GetDirectory(data.Email, form.Query, form.Sort, directoryPageSize+1, (form.Page-1)*directoryPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if len(rooms) > directoryPageSize {
+		rooms = rooms[:directoryPageSize]
+		form.HasMore = true
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	for _, room := range rooms {
+		if !room.LastActivity.IsZero() {
+			room.LastActivity = room.LastActivity.In(loc)
+		}
+	}
+
+	data.Form = form
+	data.Directory = rooms
+	app.render(w, r, http.StatusOK, "directory.html", data)
+}
+
+type invitationForm struct {
+	ID int `form:"id"`
+}
+
+func (app *application) userInvites(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+
+	invitations, err := app.invitationModel.GetPending(data.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.Invitations = invitations
+	app.render(w, r, http.StatusOK, "invites.html", data)
+}
+
+// answerInvitation loads the user's invitation from the posted form, nil is
+// returned once a response has already been sent
+func (app *application) answerInvitation(w http.ResponseWriter, r *http.Request) *models.Invitation {
+	email := app.sessionManager.GetString(r.Context(), "email")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return nil
+	}
+
+	form := invitationForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return nil
+	}
+
+	invitation, err := app.invitationModel.Get(form.ID, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "That invitation no longer exists")
+			http.Redirect(w, r, "/user/invites", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil
+	}
+
+	if err := app.invitationModel.Delete(invitation.ID); err != nil {
+		app.serverError(w, r, err)
+		return nil
+	}
+
+	return invitation
+}
+
+func (app *application) userInviteAcceptPost(w http.ResponseWriter, r *http.Request) {
+	invitation := app.answerInvitation(w, r)
+	if invitation == nil {
+		return
+	}
+
+	username := app.sessionManager.GetString(r.Context(), "username")
+	how := fmt.Sprintf("at %s's invitation", invitation.InviterName)
+
+	if err := app.joinRoom(invitation.Chatroom, invitation.Invitee, username, how); err != nil {
+		if errors.Is(err, models.ErrBanned) {
+			app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You are banned from %s", invitation.Chatroom))
+			http.Redirect(w, r, "/user/invites", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "chatroom", invitation.Chatroom)
+	http.Redirect(w, r, "/chat", http.StatusSeeOther)
+}
+
+func (app *application) userInviteDeclinePost(w http.ResponseWriter, r *http.Request) {
+	invitation := app.answerInvitation(w, r)
+	if invitation == nil {
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Declined the invitation to %s", invitation.Chatroom))
+	http.Redirect(w, r, "/user/invites", http.StatusSeeOther)
+}
+
+// chatJoin shows which chatroom an invite link is for before joining it
+func (app *application) chatJoin(w http.ResponseWriter, r *http.Request) {
+	link, err := app.inviteLinkModel.Get(r.PathValue("token"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.InviteLink = link
+	data.InviteUsable = link.Usable(time.Now())
+	app.render(w, r, http.StatusOK, "join.html", data)
+}
+
+func (app *application) chatJoinPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	username := app.sessionManager.GetString(r.Context(), "username")
+	token := r.PathValue("token")
+
+	link, err := app.inviteLinkModel.Get(token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	member, err := app.chatroomModel.IsMember(link.Chatroom, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// members following the link again should not use it up, nor should
+	// anyone banned from the chatroom
+	if !member {
+		ban, err := app.moderationModel.GetBan(link.Chatroom, email)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if ban != nil {
+			app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You are banned from %s", link.Chatroom))
+			http.Redirect(w, r, "/chat/join/"+token, http.StatusSeeOther)
+			return
+		}
+
+		if _, err := app.inviteLinkModel.Use(token); err != nil {
+			if errors.Is(err, models.ErrInviteUnusable) || errors.Is(err, models.ErrNoRecord) {
+				app.sessionManager.Put(r.Context(), "flash", "This invite link has expired")
+				http.Redirect(w, r, "/chat/join/"+token, http.StatusSeeOther)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		if err := app.joinRoom(link.Chatroom, email, username, "with an invite link"); err != nil {
+			if errors.Is(err, models.ErrBanned) {
+				app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You are banned from %s", link.Chatroom))
+				http.Redirect(w, r, "/chat/join/"+token, http.StatusSeeOther)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "chatroom", link.Chatroom)
+	http.Redirect(w, r, "/chat", http.StatusSeeOther)
+}
+
+type knockForm struct {
+	ID                  int    `form:"id"`
+	Note                string `form:"note"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) renderKnock(w http.ResponseWriter, r *http.Request, status int, name string, form knockForm) {
+	data := app.newTemplateData(r)
+
+	pending, err := app.joinRequestModel.IsPending(name, data.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.Chatroom = name
+	data.Form = form
+	data.KnockPending = pending
+	app.render(w, r, status, "knock.html", data)
+}
+
+// chatRoomKnock lets someone ask to be let into a knock chatroom
+func (app *application) chatRoomKnock(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	name := r.PathValue("name")
+
+	member, err := app.chatroomModel.IsMember(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if member {
+		http.Redirect(w, r, "/chat/room/"+url.PathEscape(name), http.StatusSeeOther)
+		return
+	}
+
+	room, err := app.roomModel.Get(name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if room.JoinPolicy != models.JoinKnock {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	app.renderKnock(w, r, http.StatusOK, name, knockForm{})
+}
+
+func (app *application) chatRoomKnockPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	username := app.sessionManager.GetString(r.Context(), "username")
+	name := r.PathValue("name")
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := knockForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Note = strings.TrimSpace(form.Note)
+
+	room, err := app.roomModel.Get(name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if room.JoinPolicy != models.JoinKnock {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	ban, err := app.moderationModel.GetBan(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if ban != nil {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You are banned from %s", name))
+		http.Redirect(w, r, "/chat", http.StatusSeeOther)
+		return
+	}
+
+	member, err := app.chatroomModel.IsMember(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if member {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You are already in %s", name))
+		http.Redirect(w, r, "/chat", http.StatusSeeOther)
+		return
+	}
+
+	form.CheckField(validator.MaxChars(form.Note, 255), "note", "This field cannot be more than 255 characters long")
+
+	if !form.Valid() {
+		app.renderKnock(w, r, http.StatusUnprocessableEntity, name, form)
+		return
+	}
+
+	pending, err := app.joinRequestModel.IsPending(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if err := app.joinRequestModel.Insert(name, email, form.Note); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// knocking again only updates the note, the room hears about each person
+	// once and the note stays in the request queue
+	if pending {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Your request to join %s has been updated", name))
+		http.Redirect(w, r, "/chat/room/"+url.PathEscape(name)+"/knock", http.StatusSeeOther)
+		return
+	}
+
+	message := fmt.Sprintf("%s asked to join, review requests at /chat/room/%s/requests", username, url.PathEscape(name))
+	if err := app.announce(name, message); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Asked to join %s, you will be told when someone answers", name))
+	http.Redirect(w, r, "/chat/room/"+url.PathEscape(name)+"/knock", http.StatusSeeOther)
+}
+
+// chatRoomRequests is the queue of people waiting to be let into the chatroom
+func (app *application) chatRoomRequests(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	name := r.PathValue("name")
+
+	allowed, err := app.canModerate(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !allowed {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	requests, err := app.joinRequestModel.GetPending(name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Chatroom = name
+	data.JoinRequests = requests
+	app.render(w, r, http.StatusOK, "requests.html", data)
+}
+
+func (app *application) chatRoomRequestApprovePost(w http.ResponseWriter, r *http.Request) {
+	app.answerJoinRequest(w, r, true)
+}
+
+func (app *application) chatRoomRequestRejectPost(w http.ResponseWriter, r *http.Request) {
+	app.answerJoinRequest(w, r, false)
+}
+
+func (app *application) answerJoinRequest(w http.ResponseWriter, r *http.Request, approved bool) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	username := app.sessionManager.GetString(r.Context(), "username")
+	name := r.PathValue("name")
+	requestsURL := "/chat/room/" + url.PathEscape(name) + "/requests"
+
+	allowed, err := app.canModerate(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !allowed {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := knockForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	request, err := app.joinRequestModel.Get(form.ID, name)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "That request has already been answered")
+			http.Redirect(w, r, requestsURL, http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.joinRequestModel.Delete(request.ID); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if approved {
+		if err := app.joinRoom(name, request.User, request.Username, fmt.Sprintf("after %s let them in", username)); err != nil {
+			if errors.Is(err, models.ErrBanned) {
+				app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s is banned from this chatroom", request.Username))
+				http.Redirect(w, r, requestsURL, http.StatusSeeOther)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Let %s in", request.Username))
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Turned down %s", request.Username))
+	}
+
+	app.notifyJoinAnswered(request, approved, username)
+
+	http.Redirect(w, r, requestsURL, http.StatusSeeOther)
+}
+
+type roomSettingsForm struct {
+	Topic               string `form:"topic"`
+	Description         string `form:"description"`
+	RemoveAvatar        bool   `form:"remove_avatar"`
+	validator.Validator `form:"-"`
+}
+
+// chatRoomSettings lets moderators edit the chatroom's topic, description and avatar
+func (app *application) chatRoomSettings(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	name := r.PathValue("name")
+
+	allowed, err := app.canModerate(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !allowed {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	room, err := app.roomModel.Get(name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Chatroom = name
+	data.Room = room
+	data.Form = roomSettingsForm{Topic: room.Topic, Description: room.Description}
+	app.render(w, r, http.StatusOK, "settings.html", data)
+}
+
+func (app *application) chatRoomSettingsPost(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	username := app.sessionManager.GetString(r.Context(), "username")
+	name := r.PathValue("name")
+
+	allowed, err := app.canModerate(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !allowed {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxAvatarSize); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := roomSettingsForm{}
+
+	if err := app.formDecoder.Decode(&form, r.PostForm); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Topic = strings.TrimSpace(form.Topic)
+	form.Description = strings.TrimSpace(form.Description)
+
+	form.CheckField(validator.MaxChars(form.Topic, 255), "topic", "This field cannot be more than 255 characters long")
+	form.CheckField(validator.MaxChars(form.Description, 1000), "description", "This field cannot be more than 1000 characters long")
+
+	var avatar []byte
+	var avatarType string
+
+	file, _, err := r.FormFile("avatar")
+	switch {
+	case err == nil:
+		defer file.Close()
+
+		avatar, err = io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		avatarType = http.DetectContentType(avatar)
+		form.CheckField(len(avatar) <= maxAvatarSize, "avatar", "Avatars cannot be larger than 256 KB")
+		form.CheckField(avatarTypes[avatarType], "avatar", "Avatars must be PNG, JPEG, GIF or WebP images")
+	case !errors.Is(err, http.ErrMissingFile):
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, err := app.roomModel.Get(name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Chatroom = name
+		data.Room = room
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "settings.html", data)
+		return
+	}
+
+	changed := []string{}
+
+	if form.Topic != room.Topic || form.Description != room.Description {
+		if err := app.roomModel.SetDetails(name, form.Topic, form.Description); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if form.Topic != room.Topic {
+			changed = append(changed, "topic")
+		}
+		if form.Description != room.Description {
+			changed = append(changed, "description")
+		}
+	}
+
+	if avatar != nil {
+		if err := app.roomModel.SetAvatar(name, avatarType, avatar); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		changed = append(changed, "avatar")
+	} else if form.RemoveAvatar && !room.AvatarUpdated.IsZero() {
+		if err := app.roomModel.DeleteAvatar(name); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		changed = append(changed, "avatar")
+	}
+
+	if len(changed) > 0 {
+		if err := app.roomUpdated(name); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		message := fmt.Sprintf("%s changed the chatroom's %s", username, strings.Join(changed, " and "))
+		if err := app.announce(name, message); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Chatroom settings saved")
+	http.Redirect(w, r, "/chat/room/"+url.PathEscape(name)+"/settings", http.StatusSeeOther)
+}
+
+// chatRoomAvatar serves the chatroom's avatar to anyone who can read the room
+func (app *application) chatRoomAvatar(w http.ResponseWriter, r *http.Request) {
+	email := app.sessionManager.GetString(r.Context(), "email")
+	name := r.PathValue("name")
+
+	allowed, err := app.canRead(name, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !allowed {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	contentType, image, err := app.roomModel.GetAvatar(name)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// the URL changes whenever the avatar does
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(image)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gochat.ayonchakroborty.net/internal/models"
+	"gochat.ayonchakroborty.net/internal/validator"
+)
+
+// defaultLinkExpiry is how long invite links last when no expiry is given
+const defaultLinkExpiry = 7 * 24 * time.Hour
+
+// joinRoom adds the user to the chatroom and lets its members know,
+// models.ErrBanned is returned if they are banned from it
+func (app *application) joinRoom(chatroom, email, username, how string) error {
+	member, err := app.chatroomModel.IsMember(chatroom, email)
+	if err != nil || member {
+		return err
+	}
+
+	ban, err := app.moderationModel.GetBan(chatroom, email)
+	if err != nil {
+		return err
+	}
+	if ban != nil {
+		return models.ErrBanned
+	}
+
+	if err := app.chatroomModel.Insert(chatroom, email, false, models.RoleMember); err != nil {
+		return err
+	}
+
+	return app.announce(chatroom, fmt.Sprintf("%s joined %s", username, how))
+}
+
+func (app *application) inviteCommand(args CommandArgs, c *Client) error {
+	if len(args.Args) == 0 {
+		return errCommandUsage
+	}
+
+	membership, err := app.chatroomModel.GetMembership(c.room(), c.email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return sendSystemMessage(c, "You are not in this chatroom")
+		}
+		return err
+	}
+	if membership.Private && models.IsGroupChat(c.room()) {
+		return sendSystemMessage(c, "Use /add to bring someone into a group chat")
+	}
+	if membership.Private {
+		return sendSystemMessage(c, "Nobody else can be added to a private chatroom")
+	}
+
+	switch strings.ToLower(args.Args[0]) {
+	case "link":
+		return app.inviteLinkCommand(args.Args[1:], c)
+	case "links":
+		return app.inviteLinksCommand(c)
+	case "revoke":
+		if len(args.Args) != 2 {
+			return errCommandUsage
+		}
+
+		allowed, err := app.canModerate(c.room(), c.email)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return sendSystemMessage(c, "Only moderators can revoke invite links")
+		}
+
+		revoked, err := app.inviteLinkModel.Delete(args.Args[1], c.room())
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return sendSystemMessage(c, "That invite link does not exist")
+		}
+		return sendSystemMessage(c, "Invite link revoked")
+	}
+
+	if len(args.Args) != 1 || !validator.Matches(args.Args[0], validator.EmailRX) {
+		return errCommandUsage
+	}
+	email := args.Args[0]
+
+	// emails without an account are invited like any other so the reply does
+	// not reveal who has signed up
+	member, err := app.chatroomModel.IsMember(c.room(), email)
+	if err != nil {
+		return err
+	}
+	if member {
+		return sendSystemMessage(c, fmt.Sprintf("%s is already in this chatroom", email))
+	}
+
+	if err := app.invitationModel.Insert(c.room(), c.email, email); err != nil {
+		return err
+	}
+
+	app.notifyUser(email, fmt.Sprintf("%s invited you to %s, answer at /user/invites", c.username, c.room()))
+
+	return sendSystemMessage(c, fmt.Sprintf("Invited %s to %s", email, c.room()))
+}
+
+// inviteLinkCommand mints a link from [expiry] [max uses]
+func (app *application) inviteLinkCommand(args []string, c *Client) error {
+	if len(args) > 2 {
+		return errCommandUsage
+	}
+
+	expiry, maxUses := defaultLinkExpiry, 0
+
+	if len(args) > 0 {
+		setting := strings.ToLower(args[0])
+		if setting == "never" {
+			expiry = 0
+		} else if after, ok := disappearTimers[setting]; ok {
+			expiry = after
+		} else {
+			return errCommandUsage
+		}
+	}
+
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return errCommandUsage
+		}
+		maxUses = n
+	}
+
+	expires := time.Time{}
+	if expiry > 0 {
+		expires = time.Now().Add(expiry)
+	}
+
+	token, err := app.inviteLinkModel.Insert(c.room(), c.email, maxUses, expires)
+	if err != nil {
+		return err
+	}
+
+	return sendSystemMessage(c, fmt.Sprintf("Invite link: /chat/join/%s (%s)", token, describeLink(expiry, maxUses)))
+}
+
+func (app *application) inviteLinksCommand(c *Client) error {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return err
+	}
+
+	links, err := app.inviteLinkModel.GetActive(c.room())
+	if err != nil {
+		return err
+	}
+
+	if len(links) == 0 {
+		return sendSystemMessage(c, "This chatroom has no active invite links")
+	}
+
+	lines := []string{"Invite links:"}
+	for _, l := range links {
+		uses := fmt.Sprintf("used %d times", l.Uses)
+		if l.MaxUses > 0 {
+			uses = fmt.Sprintf("used %d of %d times", l.Uses, l.MaxUses)
+		}
+
+		expires := "never expires"
+		if !l.Expires.IsZero() {
+			expires = "expires " + l.Expires.In(loc).Format("Mon 1/2 3:04 PM")
+		}
+
+		lines = append(lines, fmt.Sprintf("/chat/join/%s - %s, %s", l.Token, uses, expires))
+	}
+
+	return sendSystemMessage(c, strings.Join(lines, "\n"))
+}
+
+func describeLink(expiry time.Duration, maxUses int) string {
+	expires := "never expires"
+	if expiry > 0 {
+		expires = "expires in " + formatTimer(expiry)
+	}
+
+	if maxUses > 0 {
+		return fmt.Sprintf("%s, %d uses", expires, maxUses)
+	}
+
+	return expires
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// matchesKeyword reports whether keyword appears in the message as a whole word, ignoring case
+func matchesKeyword(message, keyword string) bool {
+	rx, err := regexp.Compile(`(?i)(^|\W)` + regexp.QuoteMeta(keyword) + `($|\W)`)
+	if err != nil {
+		return false
+	}
+
+	return rx.MatchString(message)
+}
+
+// notifyKeywords checks a saved message against the keyword subscriptions of the
+// chatroom's members, records an alert for each match and sends a keyword alert event
+func (app *application) notifyKeywords(msg NewMessageEvent) error {
+	keywords, err := app.keywordModel.GetForChatroom(msg.Chatroom)
+	if err != nil {
+		return err
+	}
+
+	// only alert a user once per message even if several of their keywords match
+	alerted := map[string]bool{}
+
+	for _, k := range keywords {
+		if k.User == msg.Email || alerted[k.User] || !matchesKeyword(msg.Message, k.Keyword) {
+			continue
+		}
+		alerted[k.User] = true
+
+		if err := app.alertModel.Insert(msg.ID, k.User, k.Keyword); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(KeywordAlertEvent{NewMessageEvent: msg, Keyword: k.Keyword})
+		if err != nil {
+			return fmt.Errorf("failed to marshal keyword alert event: %v", err)
+		}
+
+		app.wsManager.sendToUser(k.User, Event{Type: EventKeywordAlert, Payload: data})
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gochat.ayonchakroborty.net/internal/models"
+)
+
+var joinPolicies = map[string]string{
+	models.JoinOpen:   "anyone can join",
+	models.JoinKnock:  "people have to ask to join",
+	models.JoinInvite: "people need an invitation to join",
+}
+
+func (app *application) joinPolicyCommand(args CommandArgs, c *Client) error {
+	membership, err := app.chatroomModel.GetMembership(c.room(), c.email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+	if membership == nil || membership.Private {
+		return sendSystemMessage(c, "Join policies can only be set in group chatrooms you are in")
+	}
+
+	if len(args.Args) == 0 {
+		room, err := app.roomModel.Get(c.room())
+		if err != nil {
+			return err
+		}
+		return sendSystemMessage(c, fmt.Sprintf("In %s %s", c.room(), joinPolicies[room.JoinPolicy]))
+	}
+
+	policy := strings.ToLower(args.Args[0])
+	if _, ok := joinPolicies[policy]; !ok || len(args.Args) != 1 {
+		return errCommandUsage
+	}
+
+	allowed, err := app.canManage(c.room(), c.email)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return sendSystemMessage(c, "Only the owner can change who may join this chatroom")
+	}
+
+	if err := app.roomModel.SetJoinPolicy(c.room(), policy); err != nil {
+		return err
+	}
+
+	return app.announce(c.room(), fmt.Sprintf("%s changed the join policy, %s", c.username, joinPolicies[policy]))
+}
+
+// notifyJoinAnswered tells the user who knocked what became of their request
+func (app *application) notifyJoinAnswered(request *models.JoinRequest, approved bool, by string) {
+	data, err := json.Marshal(JoinAnsweredEvent{Chatroom: request.Chatroom, Approved: approved, By: by})
+	if err != nil {
+		app.logger.Error("failed to marshal join answer", "error", err)
+		return
+	}
+
+	app.wsManager.sendToUser(request.User, Event{Type: EventJoinAnswered, Payload: data})
+}
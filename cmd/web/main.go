@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"gochat.ayonchakroborty.net/internal/models"
+	"gochat.ayonchakroborty.net/internal/search"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
@@ -19,19 +26,47 @@ import (
 )
 
 type application struct {
-	logger         *slog.Logger
-	userModel      *models.UserModel
-	chatModel      *models.ChatModel
-	chatroomModel  *models.ChatroomModel
-	templateCache  map[string]*template.Template
-	formDecoder    *form.Decoder
-	sessionManager *scs.SessionManager
-	wsManager      *Manager
+	logger           *slog.Logger
+	userModel        *models.UserModel
+	chatModel        *models.ChatModel
+	chatroomModel    *models.ChatroomModel
+	mentionModel     *models.MentionModel
+	keywordModel     *models.KeywordModel
+	alertModel       *models.AlertModel
+	pinModel         *models.PinModel
+	bookmarkModel    *models.BookmarkModel
+	scheduledModel   *models.ScheduledMessageModel
+	reminderModel    *models.ReminderModel
+	roomModel        *models.RoomModel
+	pollModel        *models.PollModel
+	holdModel        *models.HoldModel
+	invitationModel  *models.InvitationModel
+	inviteLinkModel  *models.InviteLinkModel
+	joinRequestModel *models.JoinRequestModel
+	moderationModel  *models.ModerationModel
+	contactModel     *models.ContactModel
+	searchIndex      search.Indexer
+	templateCache    map[string]*template.Template
+	formDecoder      *form.Decoder
+	sessionManager   *scs.SessionManager
+	wsManager        *Manager
+
+	// reindexing is set while the search index is being rebuilt
+	reindexing atomic.Bool
+
+	// slowMode holds back members posting too often in slow mode chatrooms
+	slowMode slowModeTracker
+
+	// retention is how many days messages are kept for in public rooms without their own policy, zero keeps them forever
+	retention int
 }
 
 func main() {
 	addr := flag.String("addr", ":4000", "HTTP network address")
 	dsn := flag.String("dsn", "web:Popcornlovers!25@/gochat?parseTime=true", "MYSQL database source name")
+	retention := flag.Int("retention-days", 0, "Days to keep messages for in public rooms without their own policy (0 keeps them forever)")
+	retentionDryRun := flag.Bool("retention-dry-run", false, "Log how many messages retention would remove without removing them")
+	searchIndexDir := flag.String("search-index", "./search-index", "Directory for the embedded search index, empty searches with MySQL full-text instead")
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
@@ -44,6 +79,19 @@ func main() {
 
 	defer db.Close()
 
+	var searchIndex search.Indexer = &search.SQLIndex{DB: db}
+	rebuildIndex := false
+
+	if *searchIndexDir != "" {
+		diskIndex, err := search.OpenDiskIndex(*searchIndexDir)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		rebuildIndex = diskIndex.Empty()
+		searchIndex = diskIndex
+	}
+
 	templateCache, err := newTemplateCache()
 	if err != nil {
 		logger.Error(err.Error())
@@ -58,18 +106,68 @@ func main() {
 	sessionManager.Cookie.Secure = true
 
 	app := application{
-		logger:         logger,
-		userModel:      &models.UserModel{DB: db},
-		chatModel:      &models.ChatModel{DB: db},
-		chatroomModel:  &models.ChatroomModel{DB: db},
-		templateCache:  templateCache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
+		logger:           logger,
+		userModel:        &models.UserModel{DB: db},
+		chatModel:        &models.ChatModel{DB: db, Index: searchIndex},
+		chatroomModel:    &models.ChatroomModel{DB: db},
+		mentionModel:     &models.MentionModel{DB: db},
+		keywordModel:     &models.KeywordModel{DB: db},
+		alertModel:       &models.AlertModel{DB: db},
+		pinModel:         &models.PinModel{DB: db},
+		bookmarkModel:    &models.BookmarkModel{DB: db},
+		scheduledModel:   &models.ScheduledMessageModel{DB: db},
+		reminderModel:    &models.ReminderModel{DB: db},
+		roomModel:        &models.RoomModel{DB: db},
+		pollModel:        &models.PollModel{DB: db, Index: searchIndex},
+		holdModel:        &models.HoldModel{DB: db},
+		invitationModel:  &models.InvitationModel{DB: db},
+		inviteLinkModel:  &models.InviteLinkModel{DB: db},
+		joinRequestModel: &models.JoinRequestModel{DB: db},
+		moderationModel:  &models.ModerationModel{DB: db},
+		contactModel:     &models.ContactModel{DB: db},
+		searchIndex:      searchIndex,
+		templateCache:    templateCache,
+		formDecoder:      formDecoder,
+		sessionManager:   sessionManager,
+		retention:        *retention,
 	}
 
 	app.wsManager = app.NewManager()
 	app.setupEventHandlers()
 
+	if err := app.setupCommands(); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// the background jobs write to the search index, so they are stopped and
+	// waited for before it is closed
+	ctx, stopBackground := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+
+	for _, run := range []func(){
+		func() { app.runScheduler(ctx, 15*time.Second) },
+		func() { app.runReaper(ctx, time.Minute) },
+		func() { app.runRetention(ctx, time.Hour, *retentionDryRun) },
+	} {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			run()
+		}()
+	}
+
+	closeIndex := func() error {
+		stopBackground()
+		background.Wait()
+		return searchIndex.Close()
+	}
+
+	// a new index directory starts out empty, fill it from what is already in chats
+	if rebuildIndex {
+		go app.reindex("")
+	}
+
 	tlsConfig := &tls.Config{
 		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
 	}
@@ -84,11 +182,43 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	// stopping the server on a signal lets the search index write its final
+	// snapshot before the process exits
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		logger.Info("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
 	logger.Info("starting server", "addr", srv.Addr)
 
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	logger.Error(err.Error())
-	os.Exit(1)
+	if !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err.Error())
+		if err := closeIndex(); err != nil {
+			logger.Error("failed to close search index", "error", err)
+		}
+		os.Exit(1)
+	}
+
+	if err := <-shutdownErr; err != nil {
+		logger.Error(err.Error())
+	}
+
+	if err := closeIndex(); err != nil {
+		logger.Error("failed to close search index", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("stopped server")
 
 }
 
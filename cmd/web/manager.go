@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"gochat.ayonchakroborty.net/internal/models"
 )
 
 var (
@@ -26,22 +27,31 @@ type Manager struct {
 	sync.RWMutex
 
 	handlers map[string]EventHandler
+	commands map[string]Command
 }
 
 func (app *application) NewManager() *Manager {
 	m := &Manager{
 		clients:  make(ClientList),
 		handlers: make(map[string]EventHandler),
+		commands: make(map[string]Command),
 	}
 	return m
 }
 
 func (app *application) setupEventHandlers() {
 	app.wsManager.handlers[EventSendMessage] = app.SendMessage
-	app.wsManager.handlers[EventChangeChatRoom] = ChatRoomHandler
+	app.wsManager.handlers[EventChangeChatRoom] = app.ChangeChatRoom
+	app.wsManager.handlers[EventPinMessage] = app.PinMessage
+	app.wsManager.handlers[EventUnpinMessage] = app.UnpinMessage
+	app.wsManager.handlers[EventForwardMessage] = app.ForwardMessage
+	app.wsManager.handlers[EventCreatePoll] = app.CreatePoll
+	app.wsManager.handlers[EventVotePoll] = app.VotePoll
+	app.wsManager.handlers[EventClosePoll] = app.ClosePoll
+	app.wsManager.handlers[EventDeleteMessage] = app.DeleteMessage
 }
 
-func ChatRoomHandler(event Event, c *Client) error {
+func (app *application) ChangeChatRoom(event Event, c *Client) error {
 	var changeRoomEvent ChangeRoomEvent
 
 	if err := json.Unmarshal(event.Payload, &changeRoomEvent); err != nil {
@@ -52,11 +62,117 @@ func ChatRoomHandler(event Event, c *Client) error {
 		return nil
 	}
 
-	c.chatroom = changeRoomEvent.Name
+	allowed, err := app.canRead(changeRoomEvent.Name, c.email)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return sendSystemMessage(c, fmt.Sprintf("You are not in %s", changeRoomEvent.Name))
+	}
+
+	c.setRoom(changeRoomEvent.Name)
 
 	return nil
 }
 
+// canRead reports whether the user may see the chatroom's messages. Members
+// always can, anyone else only in public rooms that are open to join.
+func (app *application) canRead(chatroom, email string) (bool, error) {
+	member, err := app.chatroomModel.IsMember(chatroom, email)
+	if err != nil || member {
+		return member, err
+	}
+
+	exists, private, err := app.chatroomModel.Exists(chatroom)
+	if err != nil || !exists || private {
+		return false, err
+	}
+
+	room, err := app.roomModel.Get(chatroom)
+	if err != nil || room.JoinPolicy != models.JoinOpen {
+		return false, err
+	}
+
+	ban, err := app.moderationModel.GetBan(chatroom, email)
+	if err != nil {
+		return false, err
+	}
+
+	return ban == nil, nil
+}
+
+// Codes sent in error events when a message is refused
+const (
+	errorNotMember = "not_member"
+	errorArchived  = "archived"
+	errorReadOnly  = "read_only"
+	errorMuted     = "muted"
+	errorSlowMode  = "slow_mode"
+)
+
+// postDenial explains why the user may not post in the chatroom, an empty
+// code means they may. Members may post unless they have been muted, the
+// chatroom is archived or it is an announcement chatroom they do not moderate.
+func (app *application) postDenial(chatroom, email string) (string, string, error) {
+	membership, err := app.chatroomModel.GetMembership(chatroom, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return errorNotMember, "Join this chatroom to post in it", nil
+		}
+		return "", "", err
+	}
+
+	room, err := app.roomModel.Get(chatroom)
+	if err != nil {
+		return "", "", err
+	}
+	if !room.Archived.IsZero() {
+		return errorArchived, "This chatroom is archived and read only", nil
+	}
+	if room.Announcement && !membership.Private && !models.RoleAtLeast(membership.Role, models.RoleModerator) {
+		return errorReadOnly, "Only moderators can post in this announcement chatroom", nil
+	}
+
+	mute, err := app.moderationModel.GetMute(chatroom, email)
+	if err != nil {
+		return "", "", err
+	}
+	if mute != nil {
+		return errorMuted, "You have been muted in this chatroom", nil
+	}
+
+	return "", "", nil
+}
+
+// canPost reports whether the user may post messages in the chatroom
+func (app *application) canPost(chatroom, email string) (bool, error) {
+	code, _, err := app.postDenial(chatroom, email)
+
+	return code == "" && err == nil, err
+}
+
+// checkPost reports whether c may send a message to the chatroom right now,
+// sending c an error event saying why not when it may not
+func (app *application) checkPost(c *Client, chatroom string) (bool, error) {
+	code, message, err := app.postDenial(chatroom, c.email)
+	if err != nil {
+		return false, err
+	}
+	if code != "" {
+		return false, sendError(c, ErrorEvent{Code: code, Message: message, Chatroom: chatroom})
+	}
+
+	wait, err := app.slowModeWait(chatroom, c.email)
+	if err != nil {
+		return false, err
+	}
+	if wait > 0 {
+		return false, sendError(c, slowModeError(chatroom, wait))
+	}
+
+	return true, nil
+}
+
 func (app *application) SendMessage(event Event, c *Client) error {
 	var chatEvent SendMessageEvent
 
@@ -64,6 +180,43 @@ func (app *application) SendMessage(event Event, c *Client) error {
 		return fmt.Errorf("bad payload in request: %v", err)
 	}
 
+	if chatEvent.Message == "" {
+		return nil
+	}
+
+	allowed, err := app.canRead(chatEvent.Chatroom, c.email)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return sendSystemMessage(c, fmt.Sprintf("You are not in %s", chatEvent.Chatroom))
+	}
+
+	c.setRoom(chatEvent.Chatroom)
+
+	if name, args, ok := parseCommand(chatEvent.Message); ok {
+		return app.runCommand(name, args, c)
+	}
+
+	allowed, err = app.checkPost(c, chatEvent.Chatroom)
+	if err != nil || !allowed {
+		return err
+	}
+
+	// the sender is whoever owns the socket, not what the client claims
+	chatEvent.Email, chatEvent.From = c.email, c.username
+
+	// "//" lets a message that starts with a slash through as text
+	if strings.HasPrefix(chatEvent.Message, "//") {
+		chatEvent.Message = chatEvent.Message[1:]
+	}
+
+	return app.postMessage(chatEvent)
+}
+
+// postMessage saves a message, notifies anyone it mentions or alerts and
+// broadcasts it to everyone in its chatroom
+func (app *application) postMessage(chatEvent SendMessageEvent) error {
 	var broadMessage NewMessageEvent
 
 	broadMessage.Sent = time.Now()
@@ -72,30 +225,31 @@ func (app *application) SendMessage(event Event, c *Client) error {
 	broadMessage.Email = chatEvent.Email
 	broadMessage.Chatroom = chatEvent.Chatroom
 
+	id, err := app.chatModel.Insert(broadMessage.Chatroom, broadMessage.Email, broadMessage.Message, broadMessage.From)
+	if err != nil && !app.notIndexed(err) {
+		return fmt.Errorf("failed to save broadcast message : %v", err)
+	}
+	broadMessage.ID = id
+
+	if err := app.notifyMentions(broadMessage); err != nil {
+		log.Println("failed to notify mentions: ", err)
+	}
+
+	if err := app.notifyKeywords(broadMessage); err != nil {
+		log.Println("failed to notify keywords: ", err)
+	}
+
 	data, err := json.Marshal(broadMessage)
 	if err != nil {
 		return fmt.Errorf("failed to marshal broadcast message : %v", err)
 	}
 
-	if broadMessage.Message != "" {
-		c.chatroom = broadMessage.Chatroom
-		err = app.chatModel.Insert(broadMessage.Chatroom, broadMessage.Email, broadMessage.Message, broadMessage.From)
-		if err != nil {
-			return fmt.Errorf("failed to save broadcast message : %v", err)
-		}
-	}
-
 	outgoingEvent := Event{
 		Payload: data,
 		Type:    EventNewMessage,
 	}
 
-	for client := range c.manager.clients {
-		log.Println(client)
-		if client.chatroom == c.chatroom {
-			client.egress <- outgoingEvent
-		}
-	}
+	app.wsManager.broadcast(broadMessage.Chatroom, outgoingEvent)
 
 	return nil
 }
@@ -147,6 +301,41 @@ func (app *application) removeClient(client *Client) {
 	}
 }
 
+// broadcast delivers the event to every socket currently in the chatroom
+func (m *Manager) broadcast(chatroom string, event Event) {
+	m.RLock()
+	targets := []*Client{}
+	for client := range m.clients {
+		if client.room() == chatroom {
+			targets = append(targets, client)
+		}
+	}
+	m.RUnlock()
+
+	for _, client := range targets {
+		client.egress <- event
+	}
+}
+
+// sendToUser delivers the event to every socket the user has open, whatever
+// room it is in, and returns how many sockets it was sent to
+func (m *Manager) sendToUser(email string, event Event) int {
+	m.RLock()
+	targets := []*Client{}
+	for client := range m.clients {
+		if client.email == email {
+			targets = append(targets, client)
+		}
+	}
+	m.RUnlock()
+
+	for _, client := range targets {
+		client.egress <- event
+	}
+
+	return len(targets)
+}
+
 func checkOrigin(r *http.Request) bool {
 	origin := r.Header.Get("Origin")
 
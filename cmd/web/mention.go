@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var mentionRX = regexp.MustCompile(`(?:^|\s)@([^\s@]+)`)
+
+// parseMentions returns the distinct usernames written as @username in the message
+func parseMentions(message string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+
+	for _, match := range mentionRX.FindAllStringSubmatch(message, -1) {
+		name := strings.TrimRight(match[1], ".,!?:;")
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// notifyMentions resolves the @usernames in a saved message against the members
+// of its chatroom, records a mention for each one and sends them a mention event
+func (app *application) notifyMentions(msg NewMessageEvent) error {
+	names := parseMentions(msg.Message)
+	if len(names) == 0 {
+		return nil
+	}
+
+	members, err := app.chatroomModel.GetMembers(msg.Chatroom)
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		if member.Email == msg.Email {
+			continue
+		}
+
+		for _, name := range names {
+			if !strings.EqualFold(member.UserName, name) {
+				continue
+			}
+
+			if err := app.mentionModel.Insert(msg.ID, member.Email); err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(MentionEvent{NewMessageEvent: msg, Mentioned: member.Email})
+			if err != nil {
+				return fmt.Errorf("failed to marshal mention event: %v", err)
+			}
+
+			app.wsManager.sendToUser(member.Email, Event{Type: EventMention, Payload: data})
+			break
+		}
+	}
+
+	return nil
+}
@@ -65,6 +65,37 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// requireAdmin must come after requireAuthentication, non admins get a 404 so
+// the admin pages are not advertised
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin, err := app.userModel.IsAdmin(app.sessionManager.GetString(r.Context(), "email"))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !admin {
+			http.NotFound(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody caps how large a request body may be. It must come before noSurf,
+// which reads the body looking for the CSRF token.
+func limitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gochat.ayonchakroborty.net/internal/models"
+	"gochat.ayonchakroborty.net/internal/validator"
+)
+
+// moderationLogSize is how many actions /modlog lists
+const moderationLogSize = 20
+
+// parseSpan reads how long a ban or mute lasts, like "30m", "2h", "1d" or
+// "never". Zero means until it is lifted.
+func parseSpan(word string) (time.Duration, bool) {
+	word = strings.ToLower(word)
+	if word == "never" || word == "forever" {
+		return 0, true
+	}
+
+	i := strings.IndexFunc(word, func(r rune) bool { return r < '0' || r > '9' })
+	if i <= 0 {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(word[:i])
+	if err != nil || n < 1 {
+		return 0, false
+	}
+
+	unit, ok := parseUnit(word[i:])
+	if !ok {
+		return 0, false
+	}
+
+	return time.Duration(n) * unit, true
+}
+
+// describeSpan says how long a ban or mute lasts for announcements
+func describeSpan(span time.Duration) string {
+	switch {
+	case span == 0:
+		return "until lifted"
+	case span == time.Hour || span%(24*time.Hour) == 0:
+		return "for " + formatTimer(span)
+	case span%time.Hour == 0:
+		return fmt.Sprintf("for %d hours", span/time.Hour)
+	case span == time.Minute:
+		return "for 1 minute"
+	default:
+		return fmt.Sprintf("for %d minutes", span/time.Minute)
+	}
+}
+
+// withReason adds the reason for an action to an announcement
+func withReason(message, reason string) string {
+	if reason == "" {
+		return message
+	}
+	return fmt.Sprintf("%s: %s", message, reason)
+}
+
+// moderationTarget checks the user may moderate their chatroom and outranks
+// the member named by the command's first argument, whose email and username
+// are returned. An empty email means c has been told why not.
+func (app *application) moderationTarget(args CommandArgs, c *Client) (string, string, error) {
+	if len(args.Args) == 0 || !validator.Matches(args.Args[0], validator.EmailRX) {
+		return "", "", errCommandUsage
+	}
+	email := args.Args[0]
+
+	membership, err := app.chatroomModel.GetMembership(c.room(), c.email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return "", "", err
+	}
+	if membership == nil || membership.Private {
+		return "", "", sendSystemMessage(c, "Moderation only happens in group chatrooms you are in")
+	}
+	if !models.RoleAtLeast(membership.Role, models.RoleModerator) {
+		return "", "", sendSystemMessage(c, "Only moderators can do that")
+	}
+
+	if email == c.email {
+		return "", "", sendSystemMessage(c, "You cannot do that to yourself")
+	}
+
+	role, err := app.chatroomModel.GetRole(c.room(), email)
+	if err != nil {
+		return "", "", err
+	}
+
+	// only members are named, anyone else is treated the same whether or not
+	// they have an account so moderating does not reveal who has signed up
+	if role == "" {
+		return email, email, nil
+	}
+
+	username, err := app.userModel.GetUserField("username", email)
+	if err != nil {
+		return "", "", err
+	}
+
+	if models.RoleAtLeast(role, membership.Role) {
+		return "", "", sendSystemMessage(c, fmt.Sprintf("%s is %s, you cannot do that to them", username, withArticle(role)))
+	}
+
+	return email, username, nil
+}
+
+// notifyModerated tells the user's sockets what was done to them, sockets in
+// the chatroom are moved out of it first when they were removed
+func (app *application) notifyModerated(chatroom, email, action, by, reason string, expires time.Time) {
+	if action == models.ActionKick || action == models.ActionBan {
+		app.wsManager.RLock()
+		for client := range app.wsManager.clients {
+			if client.email == email {
+				client.moveRoom(chatroom, "")
+			}
+		}
+		app.wsManager.RUnlock()
+	}
+
+	moderated := ModeratedEvent{Chatroom: chatroom, Action: action, By: by, Reason: reason}
+	if !expires.IsZero() {
+		moderated.Expires = &expires
+	}
+
+	data, err := json.Marshal(moderated)
+	if err != nil {
+		app.logger.Error("failed to marshal moderation event", "error", err)
+		return
+	}
+
+	app.wsManager.sendToUser(email, Event{Type: EventModerated, Payload: data})
+}
+
+func (app *application) kickCommand(args CommandArgs, c *Client) error {
+	email, username, err := app.moderationTarget(args, c)
+	if err != nil || email == "" {
+		return err
+	}
+	reason := strings.Join(args.Args[1:], " ")
+
+	member, err := app.chatroomModel.IsMember(c.room(), email)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return sendSystemMessage(c, fmt.Sprintf("%s is not in this chatroom", username))
+	}
+
+	if err := app.chatroomModel.Delete(c.room(), email); err != nil {
+		return err
+	}
+
+	if err := app.moderationModel.Insert(c.room(), email, c.email, models.ActionKick, reason, time.Time{}); err != nil {
+		return err
+	}
+
+	app.notifyModerated(c.room(), email, models.ActionKick, c.username, reason, time.Time{})
+
+	return app.announce(c.room(), withReason(fmt.Sprintf("%s removed %s", c.username, username), reason))
+}
+
+// banCommand removes the user, if they are in the chatroom, and keeps them
+// from joining again for [duration]
+func (app *application) banCommand(args CommandArgs, c *Client) error {
+	email, username, err := app.moderationTarget(args, c)
+	if err != nil || email == "" {
+		return err
+	}
+
+	rest := args.Args[1:]
+	span := time.Duration(0)
+	if len(rest) > 0 {
+		if s, ok := parseSpan(rest[0]); ok {
+			span, rest = s, rest[1:]
+		}
+	}
+	reason := strings.Join(rest, " ")
+
+	expires := time.Time{}
+	if span > 0 {
+		expires = time.Now().Add(span)
+	}
+
+	if err := app.chatroomModel.Delete(c.room(), email); err != nil {
+		return err
+	}
+
+	if err := app.moderationModel.Insert(c.room(), email, c.email, models.ActionBan, reason, expires); err != nil {
+		return err
+	}
+
+	app.notifyModerated(c.room(), email, models.ActionBan, c.username, reason, expires)
+
+	return app.announce(c.room(), withReason(fmt.Sprintf("%s banned %s %s", c.username, username, describeSpan(span)), reason))
+}
+
+// muteCommand stops the user posting for [duration]
+func (app *application) muteCommand(args CommandArgs, c *Client) error {
+	email, username, err := app.moderationTarget(args, c)
+	if err != nil || email == "" {
+		return err
+	}
+
+	rest := args.Args[1:]
+	span := time.Duration(0)
+	if len(rest) > 0 {
+		if s, ok := parseSpan(rest[0]); ok {
+			span, rest = s, rest[1:]
+		}
+	}
+	reason := strings.Join(rest, " ")
+
+	expires := time.Time{}
+	if span > 0 {
+		expires = time.Now().Add(span)
+	}
+
+	member, err := app.chatroomModel.IsMember(c.room(), email)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return sendSystemMessage(c, fmt.Sprintf("%s is not in this chatroom", username))
+	}
+
+	if err := app.moderationModel.Insert(c.room(), email, c.email, models.ActionMute, reason, expires); err != nil {
+		return err
+	}
+
+	app.notifyModerated(c.room(), email, models.ActionMute, c.username, reason, expires)
+
+	return app.announce(c.room(), withReason(fmt.Sprintf("%s muted %s %s", c.username, username, describeSpan(span)), reason))
+}
+
+func (app *application) unbanCommand(args CommandArgs, c *Client) error {
+	return app.liftCommand(args, c, models.ActionUnban, "banned", "unbanned")
+}
+
+func (app *application) unmuteCommand(args CommandArgs, c *Client) error {
+	return app.liftCommand(args, c, models.ActionUnmute, "muted", "unmuted")
+}
+
+// liftCommand ends a ban or mute early, state and done describe the user
+// before and after
+func (app *application) liftCommand(args CommandArgs, c *Client, action, state, done string) error {
+	email, username, err := app.moderationTarget(args, c)
+	if err != nil || email == "" {
+		return err
+	}
+	if len(args.Args) != 1 {
+		return errCommandUsage
+	}
+
+	var current *models.ModerationAction
+	if action == models.ActionUnban {
+		current, err = app.moderationModel.GetBan(c.room(), email)
+	} else {
+		current, err = app.moderationModel.GetMute(c.room(), email)
+	}
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return sendSystemMessage(c, fmt.Sprintf("%s is not %s", username, state))
+	}
+
+	if err := app.moderationModel.Insert(c.room(), email, c.email, action, "", time.Time{}); err != nil {
+		return err
+	}
+
+	app.notifyModerated(c.room(), email, action, c.username, "", time.Time{})
+
+	return app.announce(c.room(), fmt.Sprintf("%s %s %s", c.username, done, username))
+}
+
+// modlogCommand lists the chatroom's latest moderation actions
+func (app *application) modlogCommand(args CommandArgs, c *Client) error {
+	allowed, err := app.canModerate(c.room(), c.email)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return sendSystemMessage(c, "Only moderators can see the moderation log")
+	}
+
+	actions, err := app.moderationModel.GetLatest(c.room(), moderationLogSize)
+	if err != nil {
+		return err
+	}
+
+	if len(actions) == 0 {
+		return sendSystemMessage(c, "Nobody has been moderated in this chatroom")
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return err
+	}
+
+	lines := []string{"Moderation log:"}
+	for _, a := range actions {
+		line := fmt.Sprintf("%s: %s %s %s", a.Created.In(loc).Format("Mon 1/2 3:04 PM"), a.Actor, a.Action, a.Target)
+		if !a.Expires.IsZero() {
+			line += " until " + a.Expires.In(loc).Format("Mon 1/2 3:04 PM")
+		}
+		lines = append(lines, withReason(line, a.Reason))
+	}
+
+	return sendSystemMessage(c, strings.Join(lines, "\n"))
+}
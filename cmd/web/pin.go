@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gochat.ayonchakroborty.net/internal/models"
+)
+
+// canPin reports whether the user may pin and unpin messages in the chatroom,
+// pins in archived chatrooms are left as they were
+func (app *application) canPin(chatroom, email string) (bool, error) {
+	archived, err := app.isArchived(chatroom)
+	if err != nil || archived {
+		return false, err
+	}
+
+	return app.canModerate(chatroom, email)
+}
+
+func (app *application) PinMessage(event Event, c *Client) error {
+	return app.changePin(event, c, true)
+}
+
+func (app *application) UnpinMessage(event Event, c *Client) error {
+	return app.changePin(event, c, false)
+}
+
+func (app *application) changePin(event Event, c *Client, pinned bool) error {
+	var pinEvent PinMessageEvent
+
+	if err := json.Unmarshal(event.Payload, &pinEvent); err != nil {
+		return fmt.Errorf("bad payload in request: %v", err)
+	}
+
+	chat, err := app.chatModel.GetByID(pinEvent.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return fmt.Errorf("message %d does not exist", pinEvent.ID)
+		}
+		return err
+	}
+
+	allowed, err := app.canPin(chat.Chatroom, c.email)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%s may not change pins in %s", c.email, chat.Chatroom)
+	}
+
+	eventType := EventMessagePinned
+	if pinned {
+		err = app.pinModel.Insert(chat.ID, chat.Chatroom, c.email)
+	} else {
+		eventType = EventMessageUnpinned
+		err = app.pinModel.Delete(chat.ID, chat.Chatroom)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save pin: %v", err)
+	}
+
+	data, err := json.Marshal(MessagePinnedEvent{
+		ID:       chat.ID,
+		Chatroom: chat.Chatroom,
+		From:     chat.Username,
+		Message:  chat.Message,
+		PinnedBy: c.username,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal pin event: %v", err)
+	}
+
+	app.wsManager.broadcast(chat.Chatroom, Event{Type: eventType, Payload: data})
+
+	return nil
+}
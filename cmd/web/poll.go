@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gochat.ayonchakroborty.net/internal/models"
+	"gochat.ayonchakroborty.net/internal/validator"
+)
+
+// newPollEvent converts a poll into the payload sent to clients
+func newPollEvent(p *models.Poll) *PollEvent {
+	pollEvent := &PollEvent{
+		ID:        p.ID,
+		ChatID:    p.ChatID,
+		Chatroom:  p.Chatroom,
+		Question:  p.Question,
+		Multiple:  p.Multiple,
+		Anonymous: p.Anonymous,
+		Closed:    p.IsClosed(time.Now()),
+		Options:   []PollOptionEvent{},
+	}
+
+	if !p.ClosesAt.IsZero() {
+		closesAt := p.ClosesAt
+		pollEvent.ClosesAt = &closesAt
+	}
+
+	for _, o := range p.Options {
+		pollEvent.Options = append(pollEvent.Options, PollOptionEvent{
+			Number: o.Number,
+			Text:   o.Text,
+			Votes:  o.Votes,
+			Voters: o.Voters,
+		})
+	}
+
+	return pollEvent
+}
+
+// broadcastPoll sends the poll's current tallies to everyone in its chatroom
+func (app *application) broadcastPoll(id int) error {
+	p, err := app.pollModel.Get(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(newPollEvent(p))
+	if err != nil {
+		return fmt.Errorf("failed to marshal poll event: %v", err)
+	}
+
+	app.wsManager.broadcast(p.Chatroom, Event{Type: EventPollUpdated, Payload: data})
+
+	return nil
+}
+
+func (app *application) CreatePoll(event Event, c *Client) error {
+	var pollEvent CreatePollEvent
+
+	if err := json.Unmarshal(event.Payload, &pollEvent); err != nil {
+		return fmt.Errorf("bad payload in request: %v", err)
+	}
+
+	pollEvent.Question = strings.TrimSpace(pollEvent.Question)
+	options := []string{}
+	for _, option := range pollEvent.Options {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+
+	switch {
+	case !validator.NotBlank(pollEvent.Question) || !validator.MaxChars(pollEvent.Question, 255):
+		return sendSystemMessage(c, "Polls need a question of at most 255 characters")
+	case len(options) < 2 || len(options) > 10:
+		return sendSystemMessage(c, "Polls need between 2 and 10 options")
+	case pollEvent.ClosesAt != nil && !pollEvent.ClosesAt.After(time.Now()):
+		return sendSystemMessage(c, "A poll's close time must be in the future")
+	}
+
+	for _, option := range options {
+		if !validator.MaxChars(option, 100) {
+			return sendSystemMessage(c, "Poll options cannot be more than 100 characters long")
+		}
+	}
+
+	allowed, err := app.checkPost(c, c.room())
+	if err != nil || !allowed {
+		return err
+	}
+
+	closesAt := time.Time{}
+	if pollEvent.ClosesAt != nil {
+		closesAt = *pollEvent.ClosesAt
+	}
+
+	id, err := app.pollModel.Insert(c.room(), c.email, c.username, pollEvent.Question, options,
+		pollEvent.Multiple, pollEvent.Anonymous, closesAt)
+	if err != nil && !app.notIndexed(err) {
+		return fmt.Errorf("failed to save poll: %v", err)
+	}
+
+	p, err := app.pollModel.Get(id)
+	if err != nil {
+		return err
+	}
+
+	var broadMessage NewMessageEvent
+
+	broadMessage.ID = p.ChatID
+	broadMessage.Sent = time.Now()
+	broadMessage.Message = "Poll: " + p.Question
+	broadMessage.From = c.username
+	broadMessage.Email = c.email
+	broadMessage.Chatroom = p.Chatroom
+	broadMessage.Poll = newPollEvent(p)
+
+	data, err := json.Marshal(broadMessage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast message : %v", err)
+	}
+
+	app.wsManager.broadcast(p.Chatroom, Event{Type: EventNewMessage, Payload: data})
+
+	return nil
+}
+
+func (app *application) VotePoll(event Event, c *Client) error {
+	var voteEvent VotePollEvent
+
+	if err := json.Unmarshal(event.Payload, &voteEvent); err != nil {
+		return fmt.Errorf("bad payload in request: %v", err)
+	}
+
+	p, err := app.pollModel.Get(voteEvent.PollID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return sendSystemMessage(c, fmt.Sprintf("Poll %d does not exist", voteEvent.PollID))
+		}
+		return err
+	}
+
+	member, err := app.chatroomModel.IsMember(p.Chatroom, c.email)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return sendSystemMessage(c, fmt.Sprintf("Poll %d does not exist", voteEvent.PollID))
+	}
+
+	archived, err := app.isArchived(p.Chatroom)
+	if err != nil {
+		return err
+	}
+	if archived {
+		return sendSystemMessage(c, "This chatroom is archived and read only")
+	}
+
+	if p.IsClosed(time.Now()) {
+		return sendSystemMessage(c, "This poll is closed")
+	}
+
+	if len(voteEvent.Options) > 1 && !p.Multiple {
+		return sendSystemMessage(c, "You can only pick one option in this poll")
+	}
+
+	optionIDs := []int{}
+	seen := map[int]bool{}
+	for _, number := range voteEvent.Options {
+		if number < 1 || number > len(p.Options) {
+			return sendSystemMessage(c, fmt.Sprintf("Poll %d has no option %d", p.ID, number))
+		}
+		if !seen[number] {
+			seen[number] = true
+			optionIDs = append(optionIDs, p.Options[number-1].ID)
+		}
+	}
+
+	if err := app.pollModel.Vote(p.ID, c.email, optionIDs); err != nil {
+		return fmt.Errorf("failed to save vote: %v", err)
+	}
+
+	return app.broadcastPoll(p.ID)
+}
+
+func (app *application) ClosePoll(event Event, c *Client) error {
+	var closeEvent ClosePollEvent
+
+	if err := json.Unmarshal(event.Payload, &closeEvent); err != nil {
+		return fmt.Errorf("bad payload in request: %v", err)
+	}
+
+	p, err := app.pollModel.Get(closeEvent.PollID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return sendSystemMessage(c, fmt.Sprintf("Poll %d does not exist", closeEvent.PollID))
+		}
+		return err
+	}
+
+	if p.Creator != c.email {
+		return sendSystemMessage(c, "Only the poll's creator can close it")
+	}
+
+	if err := app.pollModel.Close(p.ID); err != nil {
+		return err
+	}
+
+	return app.broadcastPoll(p.ID)
+}
+
+// closeDuePolls closes polls whose close time has passed and sends out their final tallies
+func (app *application) closeDuePolls() {
+	due, err := app.pollModel.GetDue()
+	if err != nil {
+		app.logger.Error("failed to load polls", "error", err)
+		return
+	}
+
+	for _, id := range due {
+		if err := app.pollModel.Close(id); err != nil {
+			app.logger.Error("failed to close poll", "id", id, "error", err)
+			continue
+		}
+
+		if err := app.broadcastPoll(id); err != nil {
+			app.logger.Error("failed to send poll results", "id", id, "error", err)
+		}
+	}
+}
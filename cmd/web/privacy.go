@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"gochat.ayonchakroborty.net/internal/models"
+)
+
+// dmPolicies are the choices for who can start private chatrooms with a user
+var dmPolicies = map[string]bool{
+	models.DMEveryone: true,
+	models.DMShared:   true,
+	models.DMContacts: true,
+	models.DMNobody:   true,
+}
+
+// acceptsMessages reports whether from may start a private chatroom with to.
+// Emails without an account are refused like any other, so callers can give
+// one answer that does not reveal who has signed up.
+func (app *application) acceptsMessages(from, to string) (bool, error) {
+	policy, err := app.userModel.GetDMPolicy(to)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	switch policy {
+	case models.DMEveryone:
+		return true, nil
+	case models.DMShared:
+		return app.chatroomModel.ShareRoom(from, to)
+	case models.DMContacts:
+		return app.contactModel.IsContact(to, from)
+	}
+
+	return false, nil
+}
+
+// dmRefused is the answer for both missing accounts and users who do not accept messages
+func dmRefused(email string) string {
+	return fmt.Sprintf("You cannot start a private chatroom with '%s'", email)
+}
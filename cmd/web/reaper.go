@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gochat.ayonchakroborty.net/internal/models"
+)
+
+// disappearTimers are the lifetimes a private chatroom's messages can be given
+var disappearTimers = map[string]time.Duration{
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+	"7d": 7 * 24 * time.Hour,
+}
+
+// formatTimer describes a disappearing message timer for announcements
+func formatTimer(d time.Duration) string {
+	day := 24 * time.Hour
+
+	switch {
+	case d == time.Hour:
+		return "1 hour"
+	case d == day:
+		return "1 day"
+	case d%day == 0:
+		return fmt.Sprintf("%d days", d/day)
+	default:
+		return d.String()
+	}
+}
+
+func (app *application) disappearCommand(args CommandArgs, c *Client) error {
+	if len(args.Args) != 1 {
+		return errCommandUsage
+	}
+
+	setting := strings.ToLower(args.Args[0])
+	after, ok := disappearTimers[setting]
+	if !ok && setting != "off" {
+		return errCommandUsage
+	}
+
+	membership, err := app.chatroomModel.GetMembership(c.room(), c.email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+	if membership == nil || !membership.Private {
+		return sendSystemMessage(c, "Disappearing messages can only be set in private chatrooms you are in")
+	}
+
+	room, err := app.roomModel.Get(c.room())
+	if err != nil {
+		return err
+	}
+
+	if err := app.roomModel.SetDisappearAfter(c.room(), after); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("* %s turned off disappearing messages", c.username)
+	switch {
+	case after > 0 && room.DisappearAfter == 0:
+		message = fmt.Sprintf("* %s set messages sent from now on to disappear after %s", c.username, formatTimer(after))
+	case after > 0:
+		message = fmt.Sprintf("* %s set messages to disappear after %s", c.username, formatTimer(after))
+	}
+
+	return app.postMessage(SendMessageEvent{
+		Message:  message,
+		From:     c.username,
+		Email:    c.email,
+		Chatroom: c.room(),
+	})
+}
+
+// runReaper deletes messages in rooms with disappearing messages once they
+// are older than the room's timer and tells connected clients to drop them.
+// It returns once ctx is cancelled.
+func (app *application) runReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.reapExpiredMessages()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *application) reapExpiredMessages() {
+	rooms, err := app.roomModel.GetDisappearing()
+	if err != nil {
+		app.logger.Error("failed to load disappearing rooms", "error", err)
+		return
+	}
+
+	for _, room := range rooms {
+		ids, err := app.chatModel.DeleteBetween(room.Name, room.DisappearSince, time.Now().Add(-room.DisappearAfter), "disappearing_messages")
+		if err != nil && !app.notIndexed(err) {
+			app.logger.Error("failed to delete expired messages", "chatroom", room.Name, "error", err)
+			continue
+		}
+
+		if len(ids) == 0 {
+			continue
+		}
+
+		data, err := json.Marshal(MessageExpiredEvent{Chatroom: room.Name, IDs: ids})
+		if err != nil {
+			app.logger.Error("failed to marshal expired messages", "error", err)
+			continue
+		}
+
+		app.wsManager.broadcast(room.Name, Event{Type: EventMessageExpired, Payload: data})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"gochat.ayonchakroborty.net/internal/models"
+)
+
+// notIndexed logs err if it only means a saved write missed the search index,
+// which /reindex repairs, and reports whether that was all it was
+func (app *application) notIndexed(err error) bool {
+	if !errors.Is(err, models.ErrNotIndexed) {
+		return false
+	}
+
+	app.logger.Error("write saved but the search index was not updated, run /reindex", "error", err)
+	return true
+}
+
+// reindex rebuilds the search index from chats and tells email, if set, how it went
+func (app *application) reindex(email string) {
+	if !app.reindexing.CompareAndSwap(false, true) {
+		if email != "" {
+			app.notifyUser(email, "The search index is already being rebuilt")
+		}
+		return
+	}
+	defer app.reindexing.Store(false)
+
+	app.logger.Info("rebuilding search index")
+
+	count, err := app.chatModel.Reindex()
+	if err != nil {
+		app.logger.Error("failed to rebuild search index", "indexed", count, "error", err)
+		if email != "" {
+			app.notifyUser(email, fmt.Sprintf("Rebuilding the search index failed after %d messages", count))
+		}
+		return
+	}
+
+	app.logger.Info("rebuilt search index", "indexed", count)
+	if email != "" {
+		app.notifyUser(email, fmt.Sprintf("Search index rebuilt from %d messages", count))
+	}
+}
+
+func (app *application) reindexCommand(args CommandArgs, c *Client) error {
+	if len(args.Args) != 0 {
+		return errCommandUsage
+	}
+
+	admin, err := app.userModel.IsAdmin(c.email)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return sendSystemMessage(c, "Only admins can rebuild the search index")
+	}
+
+	go app.reindex(c.email)
+
+	return sendSystemMessage(c, "Rebuilding the search index, you will get a message when it is done")
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationRX = regexp.MustCompile(`^(\d+)\s*([a-z]+)$`)
+	clockRX    = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?\s*(am|pm)?$`)
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// parseUnit turns a unit written like "m", "mins" or "hours" into a duration
+func parseUnit(unit string) (time.Duration, bool) {
+	switch unit {
+	case "m", "min", "mins", "minute", "minutes":
+		return time.Minute, true
+	case "h", "hr", "hrs", "hour", "hours":
+		return time.Hour, true
+	case "d", "day", "days":
+		return 24 * time.Hour, true
+	case "w", "week", "weeks":
+		return 7 * 24 * time.Hour, true
+	}
+
+	return 0, false
+}
+
+// parseClock reads times of day like "9am", "9:30 pm" or "17:00" from the
+// start of words and returns the hour, minute and how many words it used
+func parseClock(words []string) (int, int, int, bool) {
+	if len(words) == 0 {
+		return 0, 0, 0, false
+	}
+
+	used := 1
+	text := words[0]
+	if len(words) > 1 && (words[1] == "am" || words[1] == "pm") {
+		text += words[1]
+		used = 2
+	}
+
+	match := clockRX.FindStringSubmatch(text)
+	if match == nil {
+		return 0, 0, 0, false
+	}
+
+	hour, _ := strconv.Atoi(match[1])
+	minute := 0
+	if match[2] != "" {
+		minute, _ = strconv.Atoi(match[2])
+	}
+
+	switch match[3] {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, 0, false
+		}
+		hour %= 12
+		if match[3] == "pm" {
+			hour += 12
+		}
+	}
+
+	if hour > 23 || minute > 59 {
+		return 0, 0, 0, false
+	}
+
+	return hour, minute, used, true
+}
+
+// parseWhen reads a time like "in 2h", "tomorrow 9am", "at 5:30pm" or
+// "friday at 10am" from the start of words, relative to now. It returns the
+// time and how many words it used.
+func parseWhen(words []string, now time.Time) (time.Time, int, error) {
+	lower := make([]string, len(words))
+	for i, w := range words {
+		lower[i] = strings.ToLower(w)
+	}
+
+	if len(lower) == 0 {
+		return time.Time{}, 0, errors.New("missing when to remind you")
+	}
+
+	// in 2h, in 2 hours
+	if lower[0] == "in" {
+		for used := 2; used <= 3 && used <= len(lower); used++ {
+			match := durationRX.FindStringSubmatch(strings.Join(lower[1:used], " "))
+			if match == nil {
+				continue
+			}
+
+			n, err := strconv.Atoi(match[1])
+			if err != nil {
+				continue
+			}
+
+			unit, ok := parseUnit(match[2])
+			if !ok {
+				continue
+			}
+
+			return now.Add(time.Duration(n) * unit), used, nil
+		}
+
+		return time.Time{}, 0, errors.New("could not understand how long to wait, try 'in 30m' or 'in 2 hours'")
+	}
+
+	day := now
+	used := 0
+	dayGiven := true
+
+	switch lower[0] {
+	case "today":
+		used = 1
+	case "tomorrow":
+		day = now.AddDate(0, 0, 1)
+		used = 1
+	default:
+		if weekday, ok := weekdays[lower[0]]; ok {
+			days := (int(weekday) - int(now.Weekday()) + 7) % 7
+			if days == 0 {
+				days = 7
+			}
+			day = now.AddDate(0, 0, days)
+			used = 1
+		} else {
+			dayGiven = false
+		}
+	}
+
+	at := used < len(lower) && lower[used] == "at"
+	if at {
+		used++
+	}
+
+	hour, minute, clockUsed, ok := parseClock(lower[used:])
+	if !ok {
+		if !dayGiven || at {
+			return time.Time{}, 0, errors.New("could not understand when to remind you, try 'in 2h' or 'tomorrow 9am'")
+		}
+
+		// "tomorrow standup" means tomorrow morning
+		hour, minute = 9, 0
+	}
+	used += clockUsed
+
+	when := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+
+	// "at 9am" after 9am means tomorrow
+	if !dayGiven && !when.After(now) {
+		when = when.AddDate(0, 0, 1)
+	}
+
+	if !when.After(now) {
+		return time.Time{}, 0, errors.New("that time has already passed")
+	}
+
+	return when, used, nil
+}
+
+// parseReminder splits "/remind" arguments into who to remind, when, and what
+// about. target is "me" for private reminders or the chatroom name.
+func parseReminder(args string, now time.Time) (target string, when time.Time, message string, err error) {
+	words := strings.Fields(args)
+	if len(words) < 2 {
+		return "", time.Time{}, "", errCommandUsage
+	}
+
+	switch {
+	case strings.EqualFold(words[0], "me"):
+		target = "me"
+	case strings.HasPrefix(words[0], "#") && len(words[0]) > 1:
+		target = words[0][1:]
+	default:
+		return "", time.Time{}, "", errCommandUsage
+	}
+
+	when, used, err := parseWhen(words[1:], now)
+	if err != nil {
+		return "", time.Time{}, "", err
+	}
+
+	rest := words[1+used:]
+	if len(rest) > 0 && strings.EqualFold(rest[0], "to") {
+		rest = rest[1:]
+	}
+
+	message = strings.Join(rest, " ")
+	if message == "" {
+		return "", time.Time{}, "", errors.New("missing what to remind you about")
+	}
+
+	return target, when, message, nil
+}
+
+func (app *application) remindCommand(args CommandArgs, c *Client) error {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return err
+	}
+
+	words := args.Args
+
+	if len(words) > 0 && strings.EqualFold(words[0], "list") {
+		reminders, err := app.reminderModel.GetPending(c.email)
+		if err != nil {
+			return err
+		}
+
+		if len(reminders) == 0 {
+			return sendSystemMessage(c, "You have no pending reminders")
+		}
+
+		lines := []string{"Pending reminders:"}
+		for _, r := range reminders {
+			where := "you"
+			if r.Chatroom != "" {
+				where = "#" + r.Chatroom
+			}
+			lines = append(lines, fmt.Sprintf("%d: %s, %s, %s", r.ID, r.RemindAt.In(loc).Format("Mon 1/2 3:04 PM"), where, r.Message))
+		}
+
+		return sendSystemMessage(c, strings.Join(lines, "\n"))
+	}
+
+	if len(words) > 0 && strings.EqualFold(words[0], "delete") {
+		if len(words) != 2 {
+			return errCommandUsage
+		}
+
+		id, err := strconv.Atoi(strings.TrimPrefix(words[1], "#"))
+		if err != nil {
+			return errCommandUsage
+		}
+
+		deleted, err := app.reminderModel.Delete(id, c.email)
+		if err != nil {
+			return err
+		}
+
+		if !deleted {
+			return sendSystemMessage(c, fmt.Sprintf("You have no reminder %d", id))
+		}
+
+		return sendSystemMessage(c, fmt.Sprintf("Deleted reminder %d", id))
+	}
+
+	target, when, message, err := parseReminder(args.Raw, time.Now().In(loc))
+	if err != nil {
+		if errors.Is(err, errCommandUsage) {
+			return err
+		}
+		return sendSystemMessage(c, err.Error())
+	}
+
+	chatroom := ""
+	if target != "me" {
+		chatroom = target
+
+		allowed, err := app.canPost(chatroom, c.email)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return sendSystemMessage(c, fmt.Sprintf("You cannot post in %s", chatroom))
+		}
+	}
+
+	id, err := app.reminderModel.Insert(c.email, c.username, chatroom, message, when)
+	if err != nil {
+		return fmt.Errorf("failed to save reminder: %v", err)
+	}
+
+	return sendSystemMessage(c, fmt.Sprintf("Reminder %d set for %s", id, when.Format("Mon 1/2 3:04 PM")))
+}
+
+// sendDueReminders fires reminders whose time has come. Private reminders wait
+// until the user has a socket open so they are not lost while offline.
+func (app *application) sendDueReminders() {
+	due, err := app.reminderModel.GetDue()
+	if err != nil {
+		app.logger.Error("failed to load reminders", "error", err)
+		return
+	}
+
+	for _, r := range due {
+		if r.Chatroom == "" {
+			data, err := json.Marshal(SystemMessageEvent{Message: "Reminder: " + r.Message, Sent: time.Now()})
+			if err != nil {
+				app.logger.Error("failed to marshal reminder", "id", r.ID, "error", err)
+				continue
+			}
+
+			if app.wsManager.sendToUser(r.User, Event{Type: EventSystemMessage, Payload: data}) == 0 {
+				continue
+			}
+
+			if _, err := app.reminderModel.Delete(r.ID, r.User); err != nil {
+				app.logger.Error("failed to remove reminder", "id", r.ID, "error", err)
+			}
+			continue
+		}
+
+		if _, err := app.reminderModel.Delete(r.ID, r.User); err != nil {
+			app.logger.Error("failed to remove reminder", "id", r.ID, "error", err)
+			continue
+		}
+
+		allowed, err := app.canPost(r.Chatroom, r.User)
+		if err != nil || !allowed {
+			app.logger.Info("dropped reminder", "id", r.ID, "chatroom", r.Chatroom, "user", r.User)
+			continue
+		}
+
+		err = app.postMessage(SendMessageEvent{
+			Message:  "Reminder: " + r.Message,
+			From:     r.Username,
+			Email:    r.User,
+			Chatroom: r.Chatroom,
+		})
+		if err != nil {
+			app.logger.Error("failed to post reminder", "id", r.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionBatchSize is how many messages the purge job deletes per statement
+// so that it never holds long locks on chats
+const retentionBatchSize = 500
+
+// retentionDays returns how many days the chatroom keeps messages for, zero keeps them forever.
+// The default policy never applies to private rooms such as direct messages and
+// group chats, they only lose messages if an admin gives them their own policy.
+func (app *application) retentionDays(chatroom string, private bool, overrides map[string]int) int {
+	if days, ok := overrides[chatroom]; ok {
+		return days
+	}
+	if private {
+		return 0
+	}
+
+	return app.retention
+}
+
+func describeRetention(days int) string {
+	if days == 0 {
+		return "kept forever"
+	}
+
+	return fmt.Sprintf("kept for %d days", days)
+}
+
+// runRetention enforces the retention policies on a schedule. In dry run mode
+// it only logs how many messages each room would lose. It returns once ctx is
+// cancelled.
+func (app *application) runRetention(ctx context.Context, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		counts, err := app.purgeExpiredMessages(dryRun)
+		if err != nil {
+			app.logger.Error("failed to enforce retention", "error", err)
+		}
+
+		for chatroom, count := range counts {
+			if dryRun {
+				app.logger.Info("retention dry run", "chatroom", chatroom, "would_remove", count)
+			} else {
+				app.logger.Info("retention purge", "chatroom", chatroom, "removed", count)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeExpiredMessages deletes messages older than their room's retention
+// policy in batches and returns how many were removed per room. With dryRun
+// set nothing is deleted and the counts are what would have been removed.
+func (app *application) purgeExpiredMessages(dryRun bool) (map[string]int, error) {
+	overrides, err := app.roomModel.GetRetentionOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	chatrooms, err := app.chatModel.GetChatrooms()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+
+	for _, chatroom := range chatrooms {
+		_, private, err := app.chatroomModel.Exists(chatroom)
+		if err != nil {
+			return counts, err
+		}
+
+		days := app.retentionDays(chatroom, private, overrides)
+		if days == 0 {
+			continue
+		}
+		before := time.Now().AddDate(0, 0, -days)
+
+		if dryRun {
+			count, err := app.chatModel.CountBefore(chatroom, before)
+			if err != nil {
+				return counts, err
+			}
+			if count > 0 {
+				counts[chatroom] = count
+			}
+			continue
+		}
+
+		for {
+			ids, err := app.chatModel.DeleteBatchBefore(chatroom, before, retentionBatchSize, "retention")
+			if err != nil && !app.notIndexed(err) {
+				return counts, err
+			}
+
+			if len(ids) == 0 {
+				break
+			}
+			counts[chatroom] += len(ids)
+
+			data, err := json.Marshal(MessageExpiredEvent{Chatroom: chatroom, IDs: ids})
+			if err != nil {
+				return counts, fmt.Errorf("failed to marshal expired messages: %v", err)
+			}
+			app.wsManager.broadcast(chatroom, Event{Type: EventMessageExpired, Payload: data})
+
+			if len(ids) < retentionBatchSize {
+				break
+			}
+		}
+	}
+
+	return counts, nil
+}
+
+func (app *application) retentionCommand(args CommandArgs, c *Client) error {
+	if len(args.Args) == 0 {
+		room, err := app.roomModel.Get(c.room())
+		if err != nil {
+			return err
+		}
+
+		if room.RetentionOverride {
+			return sendSystemMessage(c, fmt.Sprintf("Messages in %s are %s", c.room(), describeRetention(room.RetentionDays)))
+		}
+
+		_, private, err := app.chatroomModel.Exists(c.room())
+		if err != nil {
+			return err
+		}
+		if private {
+			return sendSystemMessage(c, fmt.Sprintf("Messages in %s are private and %s unless an admin sets a policy for it", c.room(), describeRetention(0)))
+		}
+		return sendSystemMessage(c, fmt.Sprintf("Messages in %s follow the default policy and are %s", c.room(), describeRetention(app.retention)))
+	}
+
+	if len(args.Args) != 1 {
+		return errCommandUsage
+	}
+
+	admin, err := app.userModel.IsAdmin(c.email)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return sendSystemMessage(c, "Only admins can change retention policies")
+	}
+
+	setting := strings.ToLower(args.Args[0])
+
+	if setting == "preview" {
+		counts, err := app.purgeExpiredMessages(true)
+		if err != nil {
+			return err
+		}
+
+		if len(counts) == 0 {
+			return sendSystemMessage(c, "Retention would not remove any messages")
+		}
+
+		chatrooms := []string{}
+		for chatroom := range counts {
+			chatrooms = append(chatrooms, chatroom)
+		}
+		sort.Strings(chatrooms)
+
+		lines := []string{"Retention would remove:"}
+		for _, chatroom := range chatrooms {
+			lines = append(lines, fmt.Sprintf("%s: %d messages", chatroom, counts[chatroom]))
+		}
+
+		return sendSystemMessage(c, strings.Join(lines, "\n"))
+	}
+
+	days := -1
+	switch setting {
+	case "default":
+	case "forever":
+		days = 0
+	default:
+		days, err = strconv.Atoi(setting)
+		if err != nil || days < 1 {
+			return errCommandUsage
+		}
+	}
+
+	if err := app.roomModel.SetRetention(c.room(), days); err != nil {
+		return err
+	}
+
+	if days < 0 {
+		_, private, err := app.chatroomModel.Exists(c.room())
+		if err != nil {
+			return err
+		}
+		return app.announce(c.room(), fmt.Sprintf("%s set this chatroom to the default retention policy, messages are %s", c.username, describeRetention(app.retentionDays(c.room(), private, nil))))
+	}
+	return app.announce(c.room(), fmt.Sprintf("%s changed the retention policy, messages are %s", c.username, describeRetention(days)))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gochat.ayonchakroborty.net/internal/models"
+	"gochat.ayonchakroborty.net/internal/validator"
+)
+
+// hasRole reports whether the user holds at least the min role in the
+// chatroom. Private chatrooms have no roles, both users may do anything in them.
+func (app *application) hasRole(chatroom, email, min string) (bool, error) {
+	membership, err := app.chatroomModel.GetMembership(chatroom, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return membership.Private || models.RoleAtLeast(membership.Role, min), nil
+}
+
+// canModerate reports whether the user may keep order in the chatroom, setting
+// the topic, pinning, removing others' messages and deciding who gets in
+func (app *application) canModerate(chatroom, email string) (bool, error) {
+	return app.hasRole(chatroom, email, models.RoleModerator)
+}
+
+// canManage reports whether the user may change the chatroom itself and who
+// moderates it
+func (app *application) canManage(chatroom, email string) (bool, error) {
+	return app.hasRole(chatroom, email, models.RoleOwner)
+}
+
+// roleCommand shows roles or, for the owner, changes them. Making someone the
+// owner hands the chatroom over to them.
+func (app *application) roleCommand(args CommandArgs, c *Client) error {
+	membership, err := app.chatroomModel.GetMembership(c.room(), c.email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+	if membership == nil || membership.Private {
+		return sendSystemMessage(c, "Roles only exist in group chatrooms you are in")
+	}
+
+	if len(args.Args) == 0 {
+		return sendSystemMessage(c, fmt.Sprintf("You are %s of %s", withArticle(membership.Role), c.room()))
+	}
+
+	email := args.Args[0]
+	if len(args.Args) > 2 || !validator.Matches(email, validator.EmailRX) {
+		return errCommandUsage
+	}
+
+	role, err := app.chatroomModel.GetRole(c.room(), email)
+	if err != nil {
+		return err
+	}
+	if role == "" {
+		return sendSystemMessage(c, fmt.Sprintf("%s is not in this chatroom", email))
+	}
+
+	if len(args.Args) == 1 {
+		return sendSystemMessage(c, fmt.Sprintf("%s is %s of %s", email, withArticle(role), c.room()))
+	}
+
+	newRole := strings.ToLower(args.Args[1])
+	switch newRole {
+	case models.RoleOwner, models.RoleModerator, models.RoleMember:
+	default:
+		return errCommandUsage
+	}
+
+	if membership.Role != models.RoleOwner {
+		return sendSystemMessage(c, "Only the owner can change roles")
+	}
+	if email == c.email {
+		return sendSystemMessage(c, "Make someone else the owner to step down")
+	}
+	if role == newRole {
+		return sendSystemMessage(c, fmt.Sprintf("%s is already %s", email, withArticle(role)))
+	}
+
+	if newRole == models.RoleOwner {
+		err = app.chatroomModel.TransferOwnership(c.room(), c.email, email)
+	} else {
+		err = app.chatroomModel.SetRole(c.room(), email, newRole)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return sendSystemMessage(c, fmt.Sprintf("%s is not in this chatroom", email))
+		}
+		return err
+	}
+
+	username, err := app.userModel.GetUserField("username", email)
+	if err != nil {
+		return err
+	}
+
+	if newRole == models.RoleOwner {
+		return app.announce(c.room(), fmt.Sprintf("%s handed the chatroom over to %s", c.username, username))
+	}
+	return app.announce(c.room(), fmt.Sprintf("%s made %s %s", c.username, username, withArticle(newRole)))
+}
+
+// withArticle reads a role as part of a sentence
+func withArticle(role string) string {
+	if role == models.RoleOwner {
+		return "the owner"
+	}
+	return "a " + role
+}
+
+// succeedOwner promotes someone once the chatroom's owner has left it
+func (app *application) succeedOwner(chatroom string) error {
+	email, err := app.chatroomModel.EnsureOwner(chatroom)
+	if err != nil || email == "" {
+		return err
+	}
+
+	username, err := app.userModel.GetUserField("username", email)
+	if err != nil {
+		return err
+	}
+
+	return app.announce(chatroom, fmt.Sprintf("%s is now the owner", username))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// maxAvatarSize is the largest room avatar image that can be uploaded
+const maxAvatarSize = 256 << 10
+
+// avatarTypes are the image types room avatars can be, SVG is left out as it
+// can carry scripts
+var avatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// roomUpdated sends the chatroom's current details to every socket its
+// members have open, so pages showing the room elsewhere update too
+func (app *application) roomUpdated(chatroom string) error {
+	room, err := app.roomModel.Get(chatroom)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(RoomUpdatedEvent{
+		Chatroom:     chatroom,
+		Topic:        room.Topic,
+		Description:  room.Description,
+		AvatarURL:    room.AvatarURL(),
+		Archived:     !room.Archived.IsZero(),
+		Announcement: room.Announcement,
+		SlowMode:     int(room.SlowMode / time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal room update: %v", err)
+	}
+
+	members, err := app.chatroomModel.GetUsersList(chatroom)
+	if err != nil {
+		return err
+	}
+
+	for _, email := range members {
+		app.wsManager.sendToUser(email, Event{Type: EventRoomUpdated, Payload: data})
+	}
+
+	return nil
+}
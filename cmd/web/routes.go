@@ -28,13 +28,51 @@ func (app *application) routes() http.Handler {
 	mux.Handle("GET /chat", protected.ThenFunc(app.chat))
 	mux.Handle("POST /chat/room", protected.ThenFunc(app.chatRoomPost))
 	mux.Handle("GET /chat/room/{name}", protected.ThenFunc(app.chatRoom))
+	mux.Handle("GET /chat/room/{name}/pins", protected.ThenFunc(app.chatRoomPins))
+	mux.Handle("GET /chat/room/{name}/export", protected.ThenFunc(app.chatRoomExport))
+	mux.Handle("GET /chat/room/{name}/settings", protected.ThenFunc(app.chatRoomSettings))
+	mux.Handle("POST /chat/room/{name}/settings", alice.New(limitBody(maxAvatarSize+64<<10)).Extend(protected).ThenFunc(app.chatRoomSettingsPost))
+	mux.Handle("GET /chat/room/{name}/avatar", protected.ThenFunc(app.chatRoomAvatar))
+	mux.Handle("GET /chat/room/{name}/knock", protected.ThenFunc(app.chatRoomKnock))
+	mux.Handle("POST /chat/room/{name}/knock", protected.ThenFunc(app.chatRoomKnockPost))
+	mux.Handle("GET /chat/room/{name}/requests", protected.ThenFunc(app.chatRoomRequests))
+	mux.Handle("POST /chat/room/{name}/requests/approve", protected.ThenFunc(app.chatRoomRequestApprovePost))
+	mux.Handle("POST /chat/room/{name}/requests/reject", protected.ThenFunc(app.chatRoomRequestRejectPost))
 	mux.Handle("GET /chat/search", protected.ThenFunc(app.chatSearch))
 	mux.Handle("POST /chat/search", protected.ThenFunc(app.chatSearchPost))
+	mux.Handle("GET /chat/message/{id}", protected.ThenFunc(app.chatMessage))
+	mux.Handle("GET /chat/directory", protected.ThenFunc(app.chatDirectory))
+	mux.Handle("GET /chat/join/{token}", protected.ThenFunc(app.chatJoin))
+	mux.Handle("POST /chat/join/{token}", protected.ThenFunc(app.chatJoinPost))
 	mux.Handle("POST /chat/leave", protected.ThenFunc(app.chatLeavePost))
+	mux.Handle("GET /chat/scheduled", protected.ThenFunc(app.chatScheduled))
+	mux.Handle("POST /chat/scheduled", protected.ThenFunc(app.chatScheduledPost))
+	mux.Handle("POST /chat/scheduled/edit", protected.ThenFunc(app.chatScheduledEditPost))
+	mux.Handle("POST /chat/scheduled/cancel", protected.ThenFunc(app.chatScheduledCancelPost))
 	mux.Handle("GET /user/account", protected.ThenFunc(app.userAccount))
 	mux.Handle("POST /user/account", protected.ThenFunc(app.userAccountPost))
 	mux.Handle("POST /user/delete", protected.ThenFunc(app.userDeletePost))
 	mux.Handle("GET /user/list/{name}", protected.ThenFunc(app.usersList))
+	mux.Handle("GET /user/mentions", protected.ThenFunc(app.userMentions))
+	mux.Handle("GET /user/alerts", protected.ThenFunc(app.userAlerts))
+	mux.Handle("POST /user/keywords", protected.ThenFunc(app.userKeywordPost))
+	mux.Handle("POST /user/keywords/delete", protected.ThenFunc(app.userKeywordDeletePost))
+	mux.Handle("GET /user/privacy", protected.ThenFunc(app.userPrivacy))
+	mux.Handle("POST /user/privacy", protected.ThenFunc(app.userPrivacyPost))
+	mux.Handle("POST /user/contacts", protected.ThenFunc(app.userContactPost))
+	mux.Handle("POST /user/contacts/delete", protected.ThenFunc(app.userContactDeletePost))
+	mux.Handle("GET /user/bookmarks", protected.ThenFunc(app.userBookmarks))
+	mux.Handle("POST /user/bookmarks", protected.ThenFunc(app.userBookmarkPost))
+	mux.Handle("POST /user/bookmarks/delete", protected.ThenFunc(app.userBookmarkDeletePost))
+	mux.Handle("GET /user/invites", protected.ThenFunc(app.userInvites))
+	mux.Handle("POST /user/invites/accept", protected.ThenFunc(app.userInviteAcceptPost))
+	mux.Handle("POST /user/invites/decline", protected.ThenFunc(app.userInviteDeclinePost))
+
+	admin := protected.Append(app.requireAdmin)
+
+	mux.Handle("GET /admin/holds", admin.ThenFunc(app.adminHolds))
+	mux.Handle("POST /admin/holds", admin.ThenFunc(app.adminHoldPost))
+	mux.Handle("POST /admin/holds/delete", admin.ThenFunc(app.adminHoldDeletePost))
 
 	// websocket handler
 	mux.Handle("/ws", protected.ThenFunc(app.ServeWS))
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gochat.ayonchakroborty.net/internal/models"
+)
+
+// runScheduler posts scheduled messages, fires reminders and closes polls once
+// they are due. All of them live in the database so anything that came due
+// while the server was down is handled on the first tick after a restart.
+// It returns once ctx is cancelled.
+func (app *application) runScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.sendDueMessages()
+		app.sendDueReminders()
+		app.closeDuePolls()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *application) sendDueMessages() {
+	due, err := app.scheduledModel.GetDue()
+	if err != nil {
+		app.logger.Error("failed to load scheduled messages", "error", err)
+		return
+	}
+
+	for _, msg := range due {
+		// remove it first so a failure below never posts the message twice, and
+		// skip it if it was cancelled or already claimed since GetDue
+		if err := app.scheduledModel.Delete(msg.ID, msg.Sender); err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.logger.Error("failed to remove scheduled message", "id", msg.ID, "error", err)
+			}
+			continue
+		}
+
+		allowed, err := app.canPost(msg.Chatroom, msg.Sender)
+		if err != nil {
+			app.logger.Error("failed to check scheduled message sender", "id", msg.ID, "error", err)
+			continue
+		}
+		if !allowed {
+			app.logger.Info("dropped scheduled message", "id", msg.ID, "chatroom", msg.Chatroom, "sender", msg.Sender)
+			continue
+		}
+
+		err = app.postMessage(SendMessageEvent{
+			Message:  msg.Message,
+			From:     msg.Username,
+			Email:    msg.Sender,
+			Chatroom: msg.Chatroom,
+		})
+		if err != nil {
+			app.logger.Error("failed to post scheduled message", "id", msg.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"gochat.ayonchakroborty.net/internal/models"
+)
+
+// maxSlowMode is the longest interval slow mode can be set to
+const maxSlowMode = time.Hour
+
+// slowModeTracker remembers when each user last posted in each slow mode
+// chatroom. The zero value is ready to use.
+type slowModeTracker struct {
+	mu   sync.Mutex
+	last map[[2]string]time.Time
+}
+
+// wait returns how long the user must wait before posting again, when they
+// need not wait the post is counted against them
+func (t *slowModeTracker) wait(chatroom, email string, interval time.Duration, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.last == nil {
+		t.last = map[[2]string]time.Time{}
+	}
+
+	key := [2]string{chatroom, email}
+	if wait := t.last[key].Add(interval).Sub(now); wait > 0 {
+		return wait
+	}
+	t.last[key] = now
+
+	// nothing older than the longest interval can hold anyone back
+	if len(t.last) > 10000 {
+		for k, posted := range t.last {
+			if now.Sub(posted) > maxSlowMode {
+				delete(t.last, k)
+			}
+		}
+	}
+
+	return 0
+}
+
+// slowModeWait returns how long the user must wait before posting in the
+// chatroom, moderators never wait
+func (app *application) slowModeWait(chatroom, email string) (time.Duration, error) {
+	room, err := app.roomModel.Get(chatroom)
+	if err != nil || room.SlowMode == 0 {
+		return 0, err
+	}
+
+	role, err := app.chatroomModel.GetRole(chatroom, email)
+	if err != nil || models.RoleAtLeast(role, models.RoleModerator) {
+		return 0, err
+	}
+
+	return app.slowMode.wait(chatroom, email, room.SlowMode, time.Now()), nil
+}
+
+// describeSlowMode says how often people may post for announcements
+func describeSlowMode(interval time.Duration) string {
+	if interval == 0 {
+		return "slow mode is off"
+	}
+	return fmt.Sprintf("slow mode allows one message every %s", interval)
+}
+
+// slowModeCommand shows or, for moderators, changes the minimum time between
+// each member's messages
+func (app *application) slowModeCommand(args CommandArgs, c *Client) error {
+	if len(args.Args) == 0 {
+		room, err := app.roomModel.Get(c.room())
+		if err != nil {
+			return err
+		}
+		return sendSystemMessage(c, "In this chatroom "+describeSlowMode(room.SlowMode))
+	}
+	if len(args.Args) != 1 {
+		return errCommandUsage
+	}
+
+	interval := time.Duration(0)
+	if args.Args[0] != "off" {
+		d, err := time.ParseDuration(args.Args[0])
+		if err != nil || d < time.Second || d > maxSlowMode {
+			return errCommandUsage
+		}
+		interval = d.Round(time.Second)
+	}
+
+	membership, err := app.chatroomModel.GetMembership(c.room(), c.email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+	if membership == nil || membership.Private {
+		return sendSystemMessage(c, "Slow mode can only be set in group chatrooms you are in")
+	}
+	if !models.RoleAtLeast(membership.Role, models.RoleModerator) {
+		return sendSystemMessage(c, "Only moderators can change slow mode")
+	}
+
+	if err := app.roomModel.SetSlowMode(c.room(), interval); err != nil {
+		return err
+	}
+
+	if err := app.roomUpdated(c.room()); err != nil {
+		return err
+	}
+
+	return app.announce(c.room(), fmt.Sprintf("%s changed the chatroom, %s", c.username, describeSlowMode(interval)))
+}
+
+// slowModeError tells the client how many whole seconds to wait before posting again
+func slowModeError(chatroom string, wait time.Duration) ErrorEvent {
+	seconds := int(math.Ceil(wait.Seconds()))
+
+	return ErrorEvent{
+		Code:       errorSlowMode,
+		Message:    fmt.Sprintf("Slow mode is on, wait %ds before posting again", seconds),
+		Chatroom:   chatroom,
+		RetryAfter: seconds,
+	}
+}
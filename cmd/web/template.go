@@ -4,25 +4,48 @@ import (
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/justinas/nosurf"
 	"gochat.ayonchakroborty.net/internal/models"
 )
 
 type templateData struct {
-	CurrentYear      int
-	Form             any
-	Flash            string
-	Email            string
-	Username         string
-	Chatroom         string
-	Chats            []*models.Chat
-	PublicChatrooms  []*models.Chatroom
-	PrivateChatrooms []*models.Chatroom
-	UsersList        []string
-	IsAuthenticated  bool
-	CSRFToken        string
+	CurrentYear       int
+	Form              any
+	Flash             string
+	Email             string
+	Username          string
+	Chatroom          string
+	Room              *models.Room
+	Rooms             map[string]*models.Room
+	Chat              *models.Chat
+	Chats             []*models.Chat
+	PublicChatrooms   []*models.Chatroom
+	PrivateChatrooms  []*models.Chatroom
+	ArchivedChatrooms []*models.Chatroom
+	UsersList         []string
+	Mentions          []*models.Mention
+	Keywords          []*models.Keyword
+	Alerts            []*models.Alert
+	Pins              []*models.Pin
+	Bookmarks         []*models.Bookmark
+	Contacts          []*models.Contact
+	ScheduledMessages []*models.ScheduledMessage
+	SearchResults     []*models.SearchResult
+	Directory         []*models.DirectoryRoom
+	Invitations       []*models.Invitation
+	InviteLink        *models.InviteLink
+	InviteUsable      bool
+	KnockPending      bool
+	JoinRequests      []*models.JoinRequest
+	Holds             []*models.Hold
+	HoldAudits        []*models.HoldAudit
+	IsAuthenticated   bool
+	CSRFToken         string
 }
 
 func (app *application) newTemplateData(r *http.Request) templateData {
@@ -45,8 +68,84 @@ func humanDate(t time.Time) string {
 	return t.Format("1/2/2006")
 }
 
+// localTime formats t in the chat's timezone for datetime-local inputs
+func localTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		loc = time.UTC
+	}
+
+	return t.In(loc).Format("2006-01-02T15:04")
+}
+
+// snippetContext is how many bytes of a message are shown either side of the first search hit
+const snippetContext = 60
+
+// highlight returns an excerpt of message around the first word of query it
+// contains with every match of those words marked
+func highlight(message, query string) template.HTML {
+	terms := []string{}
+	for _, term := range strings.Fields(query) {
+		term = strings.Trim(term, `"'*+-()~<>@`)
+		if term != "" {
+			terms = append(terms, regexp.QuoteMeta(term))
+		}
+	}
+
+	if len(terms) == 0 {
+		return template.HTML(template.HTMLEscapeString(message))
+	}
+
+	rx := regexp.MustCompile(`(?i)` + strings.Join(terms, "|"))
+	matches := rx.FindAllStringIndex(message, -1)
+
+	start, end := 0, len(message)
+	if len(matches) > 0 {
+		start = max(matches[0][0]-snippetContext, 0)
+		end = min(matches[0][1]+snippetContext, len(message))
+	}
+	for start > 0 && !utf8.RuneStart(message[start]) {
+		start--
+	}
+	for end < len(message) && !utf8.RuneStart(message[end]) {
+		end++
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+
+	last := start
+	for _, match := range matches {
+		if match[0] < start || match[1] > end {
+			continue
+		}
+		b.WriteString(template.HTMLEscapeString(message[last:match[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(message[match[0]:match[1]]))
+		b.WriteString("</mark>")
+		last = match[1]
+	}
+	b.WriteString(template.HTMLEscapeString(message[last:end]))
+
+	if end < len(message) {
+		b.WriteString("…")
+	}
+
+	return template.HTML(b.String())
+}
+
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"formatTimer": formatTimer,
+	"highlight":   highlight,
+	"humanDate":   humanDate,
+	"localTime":   localTime,
+	"join":        strings.Join,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Bookmark struct {
+	ID       int
+	ChatID   int
+	Note     string
+	Chatroom string
+	Sender   string
+	Username string
+	Message  string
+	Created  time.Time
+}
+
+type BookmarkModel struct {
+	DB *sql.DB
+}
+
+// Insert saves the message for the user, saving it again replaces the note
+func (m *BookmarkModel) Insert(user string, chatID int, note string) error {
+	stmt := `INSERT INTO bookmarks (user, chat_id, note, created) VALUES (?, ?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE note = VALUES(note)`
+
+	_, err := m.DB.Exec(stmt, user, chatID, note)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *BookmarkModel) Delete(id int, user string) error {
+	stmt := `DELETE FROM bookmarks WHERE id=? AND user=?`
+
+	_, err := m.DB.Exec(stmt, id, user)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAll returns the user's bookmarks along with the saved message, bookmarks
+// to chatrooms the user is no longer in are left out
+func (m *BookmarkModel) GetAll(user string) ([]*Bookmark, error) {
+	stmt := `SELECT bookmarks.id, chats.id, bookmarks.note, chats.chatroom, chats.sender, chats.username, chats.message, chats.created
+	FROM bookmarks INNER JOIN chats ON chats.id = bookmarks.chat_id
+	INNER JOIN chatrooms ON chatrooms.name = chats.chatroom AND chatrooms.user = bookmarks.user
+	WHERE bookmarks.user = ? ORDER BY bookmarks.created DESC`
+
+	rows, err := m.DB.Query(stmt, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookmarks := []*Bookmark{}
+
+	for rows.Next() {
+		b := &Bookmark{}
+		err := rows.Scan(&b.ID, &b.ChatID, &b.Note, &b.Chatroom, &b.Sender, &b.Username, &b.Message, &b.Created)
+		if err != nil {
+			return nil, err
+		}
+		bookmarks = append(bookmarks, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return bookmarks, nil
+}
@@ -1,8 +1,17 @@
 package models
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 type Chatroom struct {
@@ -10,17 +19,44 @@ type Chatroom struct {
 	Name     string
 	User     string
 	Private  bool
+	// Role is RoleOwner, RoleModerator or RoleMember, private chatrooms have no owner
+	Role     string
 	AllUsers string
 }
 
+const (
+	// RoleOwner manages the chatroom's settings and who moderates it, each
+	// group chatroom has one
+	RoleOwner = "owner"
+	// RoleModerator keeps order, pinning and removing messages and people
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+var roleRanks = map[string]int{
+	RoleMember:    1,
+	RoleModerator: 2,
+	RoleOwner:     3,
+}
+
+// RoleAtLeast reports whether role ranks as high as min, an empty role is no
+// membership and ranks below everything
+func RoleAtLeast(role, min string) bool {
+	return roleRanks[role] >= roleRanks[min]
+}
+
 type ChatroomModel struct {
 	DB *sql.DB
 }
 
-func (m *ChatroomModel) Insert(name string, user string, private bool) error {
-	stmt := `INSERT INTO chatrooms (name, user, private) VALUES (?, ?, ?)`
+func (m *ChatroomModel) Insert(name string, user string, private bool, role string) error {
+	stmt := `INSERT INTO chatrooms (name, user, private, role) VALUES (?, ?, ?, ?)`
+
+	if IsGroupChat(name) {
+		return m.changeGroupChat(name, stmt, name, user, private, role)
+	}
 
-	_, err := m.DB.Exec(stmt, name, user, private)
+	_, err := m.DB.Exec(stmt, name, user, private, role)
 	if err != nil {
 		return err
 	}
@@ -29,12 +65,12 @@ func (m *ChatroomModel) Insert(name string, user string, private bool) error {
 }
 
 func (m *ChatroomModel) Get(chatroom string, email string, private bool) (*Chatroom, error) {
-	stmt := `SELECT * FROM chatrooms WHERE name = ? AND user = ? and private = ?`
+	stmt := `SELECT id, name, user, private, role FROM chatrooms WHERE name = ? AND user = ? and private = ?`
 
 	row := m.DB.QueryRow(stmt, chatroom, email, private)
 	cr := &Chatroom{}
 
-	err := row.Scan(&cr.ID, &cr.Name, &cr.User, &cr.Private)
+	err := row.Scan(&cr.ID, &cr.Name, &cr.User, &cr.Private, &cr.Role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -49,6 +85,10 @@ func (m *ChatroomModel) Get(chatroom string, email string, private bool) (*Chatr
 func (m *ChatroomModel) Delete(chatroom, email string) error{
 	stmt := `DELETE FROM chatrooms WHERE name=? AND user=?`
 
+	if IsGroupChat(chatroom) {
+		return m.changeGroupChat(chatroom, stmt, chatroom, email)
+	}
+
 	_, err := m.DB.Exec(stmt, chatroom, email)
 	if err != nil{
 		return err
@@ -112,15 +152,39 @@ func (m *ChatroomModel) GetUsersInChatroom(chatroom string, private bool) ([]str
 	return names, nil
 }
 
-func (m *ChatroomModel) DeletePrivateCR(name string) error {
+// DeletePrivateCR removes every membership of a private chatroom, which leaves
+// its messages unreachable, so rooms under a legal hold are kept and the
+// attempt is audited
+func (m *ChatroomModel) DeletePrivateCR(name, actor string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	held, err := isHeld(tx, HoldRoom, name)
+	if err != nil {
+		return err
+	}
+
+	if held {
+		if err := recordBlocked(tx, "user_deletion", HoldRoom, name, actor, 1); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrLegalHold
+	}
+
 	stmt := `DELETE FROM chatrooms WHERE name=?`
 
-	_, err := m.DB.Exec(stmt, name)
+	_, err = tx.Exec(stmt, name)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (m *ChatroomModel) SearchUser(email, searchEmail string) ([]*Chatroom, error) {
@@ -230,3 +294,514 @@ func (m *ChatroomModel) UserPrivateChatroom(email string) (map[string]bool, erro
 
 	return names, nil
 }
+
+func (m *ChatroomModel) GetMembers(chatroom string) ([]*User, error) {
+	stmt := `SELECT users.id, users.username, users.email FROM users
+	INNER JOIN chatrooms ON chatrooms.user=users.email AND chatrooms.name=?`
+
+	rows, err := m.DB.Query(stmt, chatroom)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		u := &User{}
+		if err := rows.Scan(&u.ID, &u.UserName, &u.Email); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (m *ChatroomModel) IsMember(chatroom, email string) (bool, error) {
+	var exists bool
+
+	stmt := "SELECT EXISTS(SELECT true FROM chatrooms WHERE name = ? AND user = ?)"
+	err := m.DB.QueryRow(stmt, chatroom, email).Scan(&exists)
+
+	return exists, err
+}
+
+// GetMembership returns the user's membership row for the chatroom
+func (m *ChatroomModel) GetMembership(chatroom, email string) (*Chatroom, error) {
+	stmt := `SELECT id, name, user, private, role FROM chatrooms WHERE name = ? AND user = ?`
+
+	cr := &Chatroom{}
+	err := m.DB.QueryRow(stmt, chatroom, email).Scan(&cr.ID, &cr.Name, &cr.User, &cr.Private, &cr.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return cr, nil
+}
+
+// GetRole returns the user's role in the chatroom, or an empty string if they
+// are not in it
+func (m *ChatroomModel) GetRole(chatroom, email string) (string, error) {
+	stmt := `SELECT role FROM chatrooms WHERE name = ? AND user = ?`
+
+	var role string
+	err := m.DB.QueryRow(stmt, chatroom, email).Scan(&role)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	return role, nil
+}
+
+// GetRoles returns the role of every member of the chatroom keyed by email
+func (m *ChatroomModel) GetRoles(chatroom string) (map[string]string, error) {
+	stmt := `SELECT user, role FROM chatrooms WHERE name = ?`
+
+	rows, err := m.DB.Query(stmt, chatroom)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := map[string]string{}
+
+	for rows.Next() {
+		var user, role string
+		if err := rows.Scan(&user, &role); err != nil {
+			return nil, err
+		}
+		roles[user] = role
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// SetRole makes a member a moderator or plain member, ownership only changes
+// hands through TransferOwnership
+func (m *ChatroomModel) SetRole(chatroom, email, role string) error {
+	stmt := `UPDATE chatrooms SET role = ? WHERE name = ? AND user = ? AND role <> 'owner'`
+
+	result, err := m.DB.Exec(stmt, role, chatroom, email)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// TransferOwnership hands the chatroom from its owner to another member, the
+// old owner stays on as a moderator
+func (m *ChatroomModel) TransferOwnership(chatroom, from, to string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE chatrooms SET role = 'owner' WHERE name = ? AND user = ? AND private = FALSE`
+
+	result, err := tx.Exec(stmt, chatroom, to)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	stmt = `UPDATE chatrooms SET role = 'moderator' WHERE name = ? AND user = ?`
+
+	if _, err := tx.Exec(stmt, chatroom, from); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EnsureOwner gives an ownerless group chatroom, such as one its owner left,
+// a new owner. The longest standing moderator is picked, then the longest
+// standing member. The new owner's email is returned, or an empty string if
+// nobody needed promoting.
+func (m *ChatroomModel) EnsureOwner(chatroom string) (string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT id, user, role FROM chatrooms WHERE name = ? AND private = FALSE
+	ORDER BY role = 'owner' DESC, role = 'moderator' DESC, id LIMIT 1 FOR UPDATE`
+
+	var id int
+	var user, role string
+	err = tx.QueryRow(stmt, chatroom).Scan(&id, &user, &role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if role == RoleOwner {
+		return "", nil
+	}
+
+	stmt = `UPDATE chatrooms SET role = 'owner' WHERE id = ?`
+
+	if _, err := tx.Exec(stmt, id); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return user, nil
+}
+
+// renamedColumns are every table and column that refers to a chatroom by name
+var renamedColumns = []struct{ table, column string }{
+	{"chatrooms", "name"},
+	{"chats", "chatroom"},
+	{"rooms", "name"},
+	{"room_avatars", "name"},
+	{"pins", "chatroom"},
+	{"forwards", "chatroom"},
+	{"polls", "chatroom"},
+	{"scheduled_messages", "chatroom"},
+	{"reminders", "chatroom"},
+	{"invitations", "chatroom"},
+	{"invite_links", "chatroom"},
+	{"join_requests", "chatroom"},
+	{"moderation_actions", "chatroom"},
+}
+
+// Rename moves a group chatroom and everything in it to a new name.
+// ErrDuplicateChatroom is returned if the name is taken.
+func (m *ChatroomModel) Rename(from, to string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := rename(tx, from, to); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func rename(tx *sql.Tx, from, to string) error {
+	var taken bool
+
+	stmt := `SELECT EXISTS(SELECT true FROM chatrooms WHERE name = ?) OR EXISTS(SELECT true FROM rooms WHERE name = ?)`
+	if err := tx.QueryRow(stmt, to, to).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return ErrDuplicateChatroom
+	}
+
+	for _, c := range renamedColumns {
+		stmt := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ?`, c.table, c.column, c.column)
+		if _, err := tx.Exec(stmt, to, from); err != nil {
+			return err
+		}
+	}
+
+	// holds follow the room so renaming cannot be used to escape one
+	stmt = `UPDATE holds SET target = ? WHERE kind = 'room' AND target = ?`
+	if _, err := tx.Exec(stmt, to, from); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GroupChatPrefix starts the name of every private chatroom between more than two users
+const GroupChatPrefix = "Group chat "
+
+// IsGroupChat reports whether the chatroom is a private chatroom between more
+// than two users
+func IsGroupChat(name string) bool {
+	return strings.HasPrefix(name, GroupChatPrefix)
+}
+
+// groupChatKey identifies a set of members however they are ordered
+func groupChatKey(members []string) string {
+	sorted := append([]string{}, members...)
+	sort.Strings(sorted)
+
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindGroupChat returns the name of the group chat between exactly members
+func (m *ChatroomModel) FindGroupChat(members []string) (string, error) {
+	stmt := `SELECT name FROM group_chats WHERE members = ?`
+
+	var name string
+	err := m.DB.QueryRow(stmt, groupChatKey(members)).Scan(&name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+
+	return name, nil
+}
+
+// InsertGroupChat starts a private chatroom between members and returns its
+// name. If they already have one, because it was started since FindGroupChat
+// was checked, that one is returned instead.
+func (m *ChatroomModel) InsertGroupChat(members []string) (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s%x", GroupChatPrefix, b)
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO group_chats (name, members) VALUES (?, ?)`
+
+	if _, err := tx.Exec(stmt, name, groupChatKey(members)); err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "group_chats_uc_members") {
+				tx.Rollback()
+				return m.FindGroupChat(members)
+			}
+		}
+		return "", err
+	}
+
+	stmt = `INSERT INTO chatrooms (name, user, private, role) VALUES (?, ?, TRUE, 'member')`
+
+	for _, member := range members {
+		if _, err := tx.Exec(stmt, name, member); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// changeGroupChat adds or removes a group chat member with stmt and updates
+// the chat's members to match in the same transaction
+func (m *ChatroomModel) changeGroupChat(name, stmt string, args ...any) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(stmt, args...); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT user FROM chatrooms WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	members := []string{}
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return err
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	if len(members) == 0 {
+		if _, err := tx.Exec(`DELETE FROM group_chats WHERE name = ?`, name); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	stmt = `UPDATE group_chats SET members = ? WHERE name = ?`
+
+	if _, err := tx.Exec(stmt, groupChatKey(members), name); err != nil {
+		// another group chat is already between these members and stays the one found for them
+		var mySQLError *mysql.MySQLError
+		if !errors.As(err, &mySQLError) || mySQLError.Number != 1062 || !strings.Contains(mySQLError.Message, "group_chats_uc_members") {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE group_chats SET members = NULL WHERE name = ?`, name); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ConvertGroupChat turns a group chat into a named invite only chatroom owned
+// by owner, keeping its history. ErrDuplicateChatroom is returned if the name
+// is taken.
+func (m *ChatroomModel) ConvertGroupChat(from, to, owner string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := rename(tx, from, to); err != nil {
+		return err
+	}
+
+	// it is no longer a group chat so it is not found for its members
+	stmt := `DELETE FROM group_chats WHERE name = ?`
+	if _, err := tx.Exec(stmt, from); err != nil {
+		return err
+	}
+
+	stmt = `UPDATE chatrooms SET private = FALSE, role = IF(user = ?, 'owner', 'member') WHERE name = ?`
+	if _, err := tx.Exec(stmt, owner, to); err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO rooms (name, join_policy, updated) VALUES (?, 'invite', UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE join_policy = VALUES(join_policy), updated = VALUES(updated)`
+	if _, err := tx.Exec(stmt, to); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Exists reports whether anyone is in the chatroom and, if so, whether it is
+// a private chatroom between two users
+func (m *ChatroomModel) Exists(chatroom string) (bool, bool, error) {
+	stmt := `SELECT COUNT(*) > 0, COALESCE(MAX(private), FALSE) FROM chatrooms WHERE name = ?`
+
+	var exists, private bool
+	err := m.DB.QueryRow(stmt, chatroom).Scan(&exists, &private)
+
+	return exists, private, err
+}
+
+// DirectoryRoom is a public chatroom as listed in the room directory
+type DirectoryRoom struct {
+	Name       string
+	Topic      string
+	JoinPolicy string
+	Members int
+	// LastActivity is when the last message was sent, zero if there are none
+	LastActivity time.Time
+	// Joined is set when the user browsing the directory is a member
+	Joined bool
+}
+
+const (
+	DirectoryPopular = "popular"
+	DirectoryRecent  = "recent"
+)
+
+// GetDirectory lists public chatrooms that are not invite only or archived whose name or topic contains search,
+// ordered by member count or last activity
+func (m *ChatroomModel) GetDirectory(email, search, order string, limit, offset int) ([]*DirectoryRoom, error) {
+	stmt := `SELECT chatrooms.name, COALESCE(rooms.topic, ''), COALESCE(rooms.join_policy, 'open'), COUNT(DISTINCT chatrooms.user),
+	(SELECT MAX(chats.created) FROM chats WHERE chats.chatroom = chatrooms.name) AS last_activity,
+	MAX(chatrooms.user = ?)
+	FROM chatrooms LEFT JOIN rooms ON rooms.name = chatrooms.name
+	WHERE chatrooms.private = FALSE AND COALESCE(rooms.join_policy, 'open') != 'invite' AND rooms.archived IS NULL`
+	args := []any{email}
+
+	if search != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(search) + "%"
+		stmt += ` AND (chatrooms.name LIKE ? OR rooms.topic LIKE ?)`
+		args = append(args, pattern, pattern)
+	}
+
+	stmt += ` GROUP BY chatrooms.name, rooms.topic, rooms.join_policy`
+
+	if order == DirectoryRecent {
+		stmt += ` ORDER BY last_activity DESC, chatrooms.name`
+	} else {
+		stmt += ` ORDER BY COUNT(DISTINCT chatrooms.user) DESC, last_activity DESC, chatrooms.name`
+	}
+
+	stmt += ` LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	directory := []*DirectoryRoom{}
+
+	for rows.Next() {
+		d := &DirectoryRoom{}
+		var lastActivity sql.NullTime
+		if err := rows.Scan(&d.Name, &d.Topic, &d.JoinPolicy, &d.Members, &lastActivity, &d.Joined); err != nil {
+			return nil, err
+		}
+		d.LastActivity = lastActivity.Time
+		directory = append(directory, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return directory, nil
+}
+
+// ShareRoom reports whether both users are members of a chatroom they chose to
+// be in. general and auto join rooms do not count since everyone is put in them.
+func (m *ChatroomModel) ShareRoom(a, b string) (bool, error) {
+	stmt := `SELECT EXISTS(SELECT true FROM chatrooms x
+	INNER JOIN chatrooms y ON y.name = x.name AND y.user = ?
+	LEFT JOIN rooms ON rooms.name = x.name
+	WHERE x.user = ? AND x.name <> 'general' AND NOT COALESCE(rooms.auto_join, FALSE))`
+
+	var shared bool
+	err := m.DB.QueryRow(stmt, b, a).Scan(&shared)
+
+	return shared, err
+}
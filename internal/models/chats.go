@@ -2,7 +2,13 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	"gochat.ayonchakroborty.net/internal/search"
 )
 
 type Chat struct {
@@ -12,27 +18,134 @@ type Chat struct {
 	Message  string
 	Created  time.Time
 	Username string
+
+	// set when the message was forwarded from another chatroom
+	ForwardedFrom    string
+	ForwardedAuthor  string
+	ForwardedCreated time.Time
+
+	// set when the message is a poll
+	Poll *Poll
 }
 
 type ChatModel struct {
 	DB *sql.DB
+	// Index is kept in step with every message written or deleted. It is
+	// updated once the write has committed, a failure returns ErrNotIndexed.
+	Index search.Indexer
 }
 
-func (m *ChatModel) Insert(chatroom string, sender string, message string, username string) error {
+// indexChat adds the message to the search index
+func indexChat(ix search.Indexer, c *Chat) error {
+	if ix == nil {
+		return nil
+	}
+
+	created := c.Created
+	if created.IsZero() {
+		created = time.Now().UTC()
+	}
+
+	err := ix.Index(search.Document{
+		ID:       c.ID,
+		Chatroom: c.Chatroom,
+		Sender:   c.Sender,
+		Username: c.Username,
+		Message:  c.Message,
+		Created:  created,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotIndexed, err)
+	}
+
+	return nil
+}
+
+func (m *ChatModel) Insert(chatroom string, sender string, message string, username string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	stmt := `INSERT INTO chats (chatroom, sender, message, created, username) 
 	VALUES (?, ?, ?, UTC_TIMESTAMP(), ?)`
 
-	_, err := m.DB.Exec(stmt, chatroom, sender, message, username)
+	result, err := tx.Exec(stmt, chatroom, sender, message, username)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	chat := &Chat{ID: int(id), Chatroom: chatroom, Sender: sender, Message: message, Username: username}
+	if err := indexChat(m.Index, chat); err != nil {
+		return int(id), err
+	}
+
+	return int(id), nil
+}
+
+// InsertForward saves a copy of source into chatroom as a message from sender,
+// keeping where the original came from
+func (m *ChatModel) InsertForward(chatroom string, sender string, username string, source *Chat) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO chats (chatroom, sender, message, created, username) 
+	VALUES (?, ?, ?, UTC_TIMESTAMP(), ?)`
+
+	result, err := tx.Exec(stmt, chatroom, sender, source.Message, username)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	// forwarding a forward keeps pointing at the first message
+	from, author, created := source.Chatroom, source.Username, source.Created
+	if source.ForwardedFrom != "" {
+		from, author, created = source.ForwardedFrom, source.ForwardedAuthor, source.ForwardedCreated
+	}
+
+	stmt = `INSERT INTO forwards (chat_id, chatroom, username, created) VALUES (?, ?, ?, ?)`
+
+	_, err = tx.Exec(stmt, id, from, author, created)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	chat := &Chat{ID: int(id), Chatroom: chatroom, Sender: sender, Message: source.Message, Username: username}
+	if err := indexChat(m.Index, chat); err != nil {
+		return int(id), err
+	}
+
+	return int(id), nil
 }
 
 func (m *ChatModel) Get(chatroom string) ([]*Chat, error) {
-	stmt := `SELECT * FROM chats WHERE chatroom = ?
-	ORDER BY created ASC LIMIT 200`
+	stmt := `SELECT chats.id, chats.chatroom, chats.sender, chats.message, chats.created, chats.username,
+	forwards.chatroom, forwards.username, forwards.created
+	FROM chats LEFT JOIN forwards ON forwards.chat_id = chats.id
+	WHERE chats.chatroom = ?
+	ORDER BY chats.created ASC LIMIT 200`
 	rows, err := m.DB.Query(stmt, chatroom)
 	if err != nil {
 		return nil, err
@@ -42,10 +155,13 @@ func (m *ChatModel) Get(chatroom string) ([]*Chat, error) {
 	chats := []*Chat{}
 	for rows.Next() {
 		c := &Chat{}
-		err = rows.Scan(&c.ID, &c.Chatroom, &c.Sender, &c.Message, &c.Created, &c.Username)
+		var from, author sql.NullString
+		var created sql.NullTime
+		err = rows.Scan(&c.ID, &c.Chatroom, &c.Sender, &c.Message, &c.Created, &c.Username, &from, &author, &created)
 		if err != nil {
 			return nil, err
 		}
+		c.ForwardedFrom, c.ForwardedAuthor, c.ForwardedCreated = from.String, author.String, created.Time
 		chats = append(chats, c)
 	}
 
@@ -56,13 +172,194 @@ func (m *ChatModel) Get(chatroom string) ([]*Chat, error) {
 	return chats, nil
 }
 
+// DeleteUser deletes every message the user sent, messages covered by a hold
+// are kept and ErrLegalHold is returned once the rest are gone
 func (m *ChatModel) DeleteUser(email string) (error){
-	stmt := `DELETE FROM chats WHERE sender=?`
-
-	_, err := m.DB.Exec(stmt, email)
+	_, held, err := m.deleteWhere("user_deletion", HoldUser, email, email, "sender = ?", []any{email}, 0)
 	if err != nil{
 		return err
 	}
 
+	if held > 0 {
+		return ErrLegalHold
+	}
+
+	return nil
+}
+
+// Delete removes a single message unless it is covered by a hold, in which
+// case ErrLegalHold is returned
+func (m *ChatModel) Delete(id int, actor string) error {
+	ids, held, err := m.deleteWhere("message_deletion", "message", strconv.Itoa(id), actor, "id = ?", []any{id}, 0)
+	if err != nil {
+		return err
+	}
+
+	if held > 0 {
+		return ErrLegalHold
+	}
+	if len(ids) == 0 {
+		return ErrNoRecord
+	}
+
 	return nil
-}
\ No newline at end of file
+}
+
+func (m *ChatModel) GetByID(id int) (*Chat, error) {
+	stmt := `SELECT chats.id, chats.chatroom, chats.sender, chats.message, chats.created, chats.username,
+	forwards.chatroom, forwards.username, forwards.created
+	FROM chats LEFT JOIN forwards ON forwards.chat_id = chats.id
+	WHERE chats.id = ?`
+
+	c := &Chat{}
+	var from, author sql.NullString
+	var created sql.NullTime
+	err := m.DB.QueryRow(stmt, id).Scan(&c.ID, &c.Chatroom, &c.Sender, &c.Message, &c.Created, &c.Username, &from, &author, &created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	c.ForwardedFrom, c.ForwardedAuthor, c.ForwardedCreated = from.String, author.String, created.Time
+
+	return c, nil
+}
+
+// DeleteBetween hard deletes the chatroom's messages sent from since, or from
+// the start when since is zero, until before and returns the ids of the
+// deleted messages. Held messages are kept and the blocked deletion is
+// audited under action.
+func (m *ChatModel) DeleteBetween(chatroom string, since, before time.Time, action string) ([]int, error) {
+	ids, _, err := m.deleteWhere(action, HoldRoom, chatroom, "", "chatroom = ? AND created >= ? AND created < ?", []any{chatroom, since.UTC(), before.UTC()}, 0)
+
+	return ids, err
+}
+
+// GetChatrooms returns the name of every chatroom that has messages
+func (m *ChatModel) GetChatrooms() ([]string, error) {
+	stmt := `SELECT DISTINCT chatroom FROM chats`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		n := ""
+		if err := rows.Scan(&n); err != nil {
+			return nil, err
+		}
+		names = append(names, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// CountBefore counts the chatroom's messages sent before the given time that
+// DeleteBatchBefore would remove, held messages are not counted
+func (m *ChatModel) CountBefore(chatroom string, before time.Time) (int, error) {
+	var count int
+
+	stmt := `SELECT COUNT(*) FROM chats WHERE chatroom = ? AND created < ? AND ` + chatNotHeld
+	err := m.DB.QueryRow(stmt, chatroom, before.UTC()).Scan(&count)
+
+	return count, err
+}
+
+// DeleteBatchBefore hard deletes up to limit of the chatroom's oldest messages
+// sent before the given time and returns the ids of the deleted messages. Held
+// messages are kept and audited under action once the last batch is reached.
+func (m *ChatModel) DeleteBatchBefore(chatroom string, before time.Time, limit int, action string) ([]int, error) {
+	ids, _, err := m.deleteWhere(action, HoldRoom, chatroom, "", "chatroom = ? AND created < ?", []any{chatroom, before.UTC()}, limit)
+
+	return ids, err
+}
+
+// deleteWhere deletes the messages matching where that no hold covers, up to
+// limit of them when limit is positive. It returns the ids it deleted and how
+// many matching messages were held back, recording an audit entry for them
+// with kind and target describing what was being deleted.
+func (m *ChatModel) deleteWhere(action, kind, target, actor, where string, args []any, limit int) ([]int, int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT id FROM chats WHERE ` + where + ` AND ` + chatNotHeld + ` ORDER BY id`
+	selectArgs := args
+	if limit > 0 {
+		stmt += ` LIMIT ?`
+		selectArgs = append(append([]any{}, args...), limit)
+	}
+
+	rows, err := tx.Query(stmt+` FOR UPDATE`, selectArgs...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	idArgs := []any{}
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+		idArgs = append(idArgs, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if len(ids) > 0 {
+		stmt = `DELETE FROM chats WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+
+		if _, err := tx.Exec(stmt, idArgs...); err != nil {
+			return nil, 0, err
+		}
+
+	}
+
+	// Only the final batch counts what was held back so a purge is audited once
+	held := 0
+	if limit <= 0 || len(ids) < limit {
+		stmt = `SELECT COUNT(*) FROM chats WHERE ` + where + ` AND ` + chatHeld
+
+		if err := tx.QueryRow(stmt, args...).Scan(&held); err != nil {
+			return nil, 0, err
+		}
+
+		if held > 0 {
+			if err := recordBlocked(tx, action, kind, target, actor, held); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+
+	if m.Index != nil {
+		for _, id := range ids {
+			if err := m.Index.Delete(id); err != nil {
+				return ids, held, fmt.Errorf("%w: %v", ErrNotIndexed, err)
+			}
+		}
+	}
+
+	return ids, held, nil
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Contact is someone a user lets message them when they only accept contacts
+type Contact struct {
+	ID      int
+	User    string
+	Contact string
+	Created time.Time
+}
+
+type ContactModel struct {
+	DB *sql.DB
+}
+
+func (m *ContactModel) Insert(user, contact string) error {
+	stmt := `INSERT INTO contacts (user, contact, created) VALUES (?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, user, contact)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "contacts_uc_user_contact") {
+				return ErrDuplicateContact
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m *ContactModel) Delete(id int, user string) error {
+	stmt := `DELETE FROM contacts WHERE id=? AND user=?`
+
+	_, err := m.DB.Exec(stmt, id, user)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *ContactModel) GetAll(user string) ([]*Contact, error) {
+	stmt := `SELECT id, user, contact, created FROM contacts WHERE user=? ORDER BY contact`
+
+	rows, err := m.DB.Query(stmt, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []*Contact{}
+
+	for rows.Next() {
+		c := &Contact{}
+		if err := rows.Scan(&c.ID, &c.User, &c.Contact, &c.Created); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// IsContact reports whether user has added contact to their contacts
+func (m *ContactModel) IsContact(user, contact string) (bool, error) {
+	var exists bool
+
+	stmt := "SELECT EXISTS(SELECT true FROM contacts WHERE user = ? AND contact = ?)"
+	err := m.DB.QueryRow(stmt, user, contact).Scan(&exists)
+
+	return exists, err
+}
@@ -12,4 +12,25 @@ var (
 	
 	// Trys to create an account with an email already in use
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+
+	// User already subscribed to the same keyword
+	ErrDuplicateKeyword = errors.New("models: duplicate keyword")
+
+	// Content is covered by a legal hold and cannot be deleted
+	ErrLegalHold = errors.New("models: content under legal hold")
+
+	// Invite link has expired or been used up
+	ErrInviteUnusable = errors.New("models: invite link expired or used up")
+
+	// User is banned from the chatroom they are trying to join
+	ErrBanned = errors.New("models: banned from chatroom")
+
+	// Renaming a chatroom to a name already in use
+	ErrDuplicateChatroom = errors.New("models: duplicate chatroom")
+
+	// Write was saved but the search index could not be updated, /reindex repairs it
+	ErrNotIndexed = errors.New("models: search index not updated")
+
+	// User already has the same contact
+	ErrDuplicateContact = errors.New("models: duplicate contact")
 )
\ No newline at end of file
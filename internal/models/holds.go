@@ -0,0 +1,154 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	HoldUser = "user"
+	HoldRoom = "room"
+)
+
+// chatNotHeld is a condition on chats that leaves out messages in held rooms
+// or sent by held users, every statement deleting from chats must include it
+const chatNotHeld = `NOT EXISTS (SELECT true FROM holds
+	WHERE (holds.kind = 'room' AND holds.target = chats.chatroom) OR (holds.kind = 'user' AND holds.target = chats.sender))`
+
+// chatHeld is the opposite of chatNotHeld, used to count what a deletion left behind
+const chatHeld = `EXISTS (SELECT true FROM holds
+	WHERE (holds.kind = 'room' AND holds.target = chats.chatroom) OR (holds.kind = 'user' AND holds.target = chats.sender))`
+
+// Hold stops content belonging to a user or a room from being deleted
+type Hold struct {
+	ID int
+	// Kind is HoldUser or HoldRoom
+	Kind      string
+	Target    string
+	Reason    string
+	CreatedBy string
+	Created   time.Time
+}
+
+// HoldAudit records a deletion that a hold blocked
+type HoldAudit struct {
+	ID      int
+	Action  string
+	Kind    string
+	Target  string
+	Actor   string
+	Blocked int
+	Created time.Time
+}
+
+type HoldModel struct {
+	DB *sql.DB
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx so holds can be checked
+// inside the transaction doing the deletion
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func isHeld(q queryer, kind, target string) (bool, error) {
+	var held bool
+
+	stmt := "SELECT EXISTS(SELECT true FROM holds WHERE kind = ? AND target = ?)"
+	err := q.QueryRow(stmt, kind, target).Scan(&held)
+
+	return held, err
+}
+
+func recordBlocked(q queryer, action, kind, target, actor string, blocked int) error {
+	stmt := `INSERT INTO hold_audit (action, kind, target, actor, blocked, created)
+	VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := q.Exec(stmt, action, kind, target, actor, blocked)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *HoldModel) Insert(kind, target, reason, createdBy string) error {
+	stmt := `INSERT INTO holds (kind, target, reason, created_by, created) VALUES (?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, kind, target, reason, createdBy)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *HoldModel) Delete(id int) error {
+	stmt := `DELETE FROM holds WHERE id=?`
+
+	_, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *HoldModel) IsHeld(kind, target string) (bool, error) {
+	return isHeld(m.DB, kind, target)
+}
+
+func (m *HoldModel) GetAll() ([]*Hold, error) {
+	stmt := `SELECT id, kind, target, reason, created_by, created FROM holds ORDER BY created DESC`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []*Hold{}
+
+	for rows.Next() {
+		h := &Hold{}
+		if err := rows.Scan(&h.ID, &h.Kind, &h.Target, &h.Reason, &h.CreatedBy, &h.Created); err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return holds, nil
+}
+
+// GetAudit returns the most recent deletions blocked by holds
+func (m *HoldModel) GetAudit() ([]*HoldAudit, error) {
+	stmt := `SELECT id, action, kind, target, actor, blocked, created FROM hold_audit
+	ORDER BY created DESC LIMIT 100`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	audits := []*HoldAudit{}
+
+	for rows.Next() {
+		a := &HoldAudit{}
+		if err := rows.Scan(&a.ID, &a.Action, &a.Kind, &a.Target, &a.Actor, &a.Blocked, &a.Created); err != nil {
+			return nil, err
+		}
+		audits = append(audits, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return audits, nil
+}
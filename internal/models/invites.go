@@ -0,0 +1,263 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// Invitation asks a user to join a chatroom, it is removed once answered
+type Invitation struct {
+	ID          int
+	Chatroom    string
+	Inviter     string
+	InviterName string
+	Invitee     string
+	Created     time.Time
+}
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// Insert invites invitee to the chatroom, inviting someone again refreshes
+// the existing invitation
+func (m *InvitationModel) Insert(chatroom, inviter, invitee string) error {
+	stmt := `INSERT INTO invitations (chatroom, inviter, invitee, created) VALUES (?, ?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE inviter = VALUES(inviter), created = VALUES(created)`
+
+	_, err := m.DB.Exec(stmt, chatroom, inviter, invitee)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Get returns one of invitee's invitations
+func (m *InvitationModel) Get(id int, invitee string) (*Invitation, error) {
+	stmt := `SELECT invitations.id, invitations.chatroom, invitations.inviter, users.username, invitations.invitee, invitations.created
+	FROM invitations JOIN users ON users.email = invitations.inviter
+	WHERE invitations.id = ? AND invitations.invitee = ?`
+
+	i := &Invitation{}
+	err := m.DB.QueryRow(stmt, id, invitee).Scan(&i.ID, &i.Chatroom, &i.Inviter, &i.InviterName, &i.Invitee, &i.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return i, nil
+}
+
+func (m *InvitationModel) Delete(id int) error {
+	stmt := `DELETE FROM invitations WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPending returns the invitations invitee has not answered, newest first
+func (m *InvitationModel) GetPending(invitee string) ([]*Invitation, error) {
+	stmt := `SELECT invitations.id, invitations.chatroom, invitations.inviter, users.username, invitations.invitee, invitations.created
+	FROM invitations JOIN users ON users.email = invitations.inviter
+	WHERE invitations.invitee = ? ORDER BY invitations.created DESC`
+
+	rows, err := m.DB.Query(stmt, invitee)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		i := &Invitation{}
+		if err := rows.Scan(&i.ID, &i.Chatroom, &i.Inviter, &i.InviterName, &i.Invitee, &i.Created); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// InviteLink lets anyone holding its token join the chatroom
+type InviteLink struct {
+	Token    string
+	Chatroom string
+	Creator  string
+	// MaxUses is zero when the link can be used any number of times
+	MaxUses int
+	Uses    int
+	// Expires is zero when the link never expires
+	Expires time.Time
+	Created time.Time
+}
+
+// Usable reports whether the link can still be used to join
+func (l *InviteLink) Usable(now time.Time) bool {
+	if !l.Expires.IsZero() && !now.Before(l.Expires) {
+		return false
+	}
+
+	return l.MaxUses == 0 || l.Uses < l.MaxUses
+}
+
+type InviteLinkModel struct {
+	DB *sql.DB
+}
+
+// newToken returns a random url safe token that cannot be guessed
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Insert mints a link to the chatroom and returns its token, zero maxUses or
+// expires leave that limit off
+func (m *InviteLinkModel) Insert(chatroom, creator string, maxUses int, expires time.Time) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	var expiresAt sql.NullTime
+	if !expires.IsZero() {
+		expiresAt = sql.NullTime{Time: expires.UTC(), Valid: true}
+	}
+
+	stmt := `INSERT INTO invite_links (token, chatroom, creator, max_uses, uses, expires, created)
+	VALUES (?, ?, ?, ?, 0, ?, UTC_TIMESTAMP())`
+
+	_, err = m.DB.Exec(stmt, token, chatroom, creator, maxUses, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (m *InviteLinkModel) Get(token string) (*InviteLink, error) {
+	stmt := `SELECT token, chatroom, creator, max_uses, uses, expires, created FROM invite_links WHERE token = ?`
+
+	l := &InviteLink{}
+	var expires sql.NullTime
+	err := m.DB.QueryRow(stmt, token).Scan(&l.Token, &l.Chatroom, &l.Creator, &l.MaxUses, &l.Uses, &expires, &l.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	l.Expires = expires.Time
+
+	return l, nil
+}
+
+// Use counts a join against the link, ErrInviteUnusable is returned once the
+// link has expired or run out of uses
+func (m *InviteLinkModel) Use(token string) (*InviteLink, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT token, chatroom, creator, max_uses, uses, expires, created FROM invite_links WHERE token = ? FOR UPDATE`
+
+	l := &InviteLink{}
+	var expires sql.NullTime
+	err = tx.QueryRow(stmt, token).Scan(&l.Token, &l.Chatroom, &l.Creator, &l.MaxUses, &l.Uses, &expires, &l.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	l.Expires = expires.Time
+
+	if !l.Usable(time.Now()) {
+		return nil, ErrInviteUnusable
+	}
+
+	stmt = `UPDATE invite_links SET uses = uses + 1 WHERE token = ?`
+
+	if _, err := tx.Exec(stmt, token); err != nil {
+		return nil, err
+	}
+	l.Uses++
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Delete revokes a link to the chatroom
+func (m *InviteLinkModel) Delete(token, chatroom string) (bool, error) {
+	stmt := `DELETE FROM invite_links WHERE token = ? AND chatroom = ?`
+
+	result, err := m.DB.Exec(stmt, token, chatroom)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// GetActive returns the chatroom's links that can still be used
+func (m *InviteLinkModel) GetActive(chatroom string) ([]*InviteLink, error) {
+	stmt := `SELECT token, chatroom, creator, max_uses, uses, expires, created FROM invite_links
+	WHERE chatroom = ? AND (expires IS NULL OR expires > UTC_TIMESTAMP()) AND (max_uses = 0 OR uses < max_uses)
+	ORDER BY created`
+
+	rows, err := m.DB.Query(stmt, chatroom)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []*InviteLink{}
+
+	for rows.Next() {
+		l := &InviteLink{}
+		var expires sql.NullTime
+		if err := rows.Scan(&l.Token, &l.Chatroom, &l.Creator, &l.MaxUses, &l.Uses, &expires, &l.Created); err != nil {
+			return nil, err
+		}
+		l.Expires = expires.Time
+		links = append(links, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// JoinRequest asks to be let into a knock chatroom, it is removed once answered
+type JoinRequest struct {
+	ID       int
+	Chatroom string
+	User     string
+	Username string
+	Note     string
+	Created  time.Time
+}
+
+type JoinRequestModel struct {
+	DB *sql.DB
+}
+
+// Insert records the user's request to join, asking again replaces the note
+func (m *JoinRequestModel) Insert(chatroom, user, note string) error {
+	stmt := `INSERT INTO join_requests (chatroom, user, note, created) VALUES (?, ?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE note = VALUES(note), created = VALUES(created)`
+
+	_, err := m.DB.Exec(stmt, chatroom, user, note)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Get returns one of the chatroom's pending requests
+func (m *JoinRequestModel) Get(id int, chatroom string) (*JoinRequest, error) {
+	stmt := `SELECT join_requests.id, join_requests.chatroom, join_requests.user, users.username, join_requests.note, join_requests.created
+	FROM join_requests JOIN users ON users.email = join_requests.user
+	WHERE join_requests.id = ? AND join_requests.chatroom = ?`
+
+	j := &JoinRequest{}
+	err := m.DB.QueryRow(stmt, id, chatroom).Scan(&j.ID, &j.Chatroom, &j.User, &j.Username, &j.Note, &j.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return j, nil
+}
+
+// IsPending reports whether the user is waiting to be let into the chatroom
+func (m *JoinRequestModel) IsPending(chatroom, user string) (bool, error) {
+	var pending bool
+
+	stmt := "SELECT EXISTS(SELECT true FROM join_requests WHERE chatroom = ? AND user = ?)"
+	err := m.DB.QueryRow(stmt, chatroom, user).Scan(&pending)
+
+	return pending, err
+}
+
+func (m *JoinRequestModel) Delete(id int) error {
+	stmt := `DELETE FROM join_requests WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPending returns the chatroom's unanswered requests, oldest first
+func (m *JoinRequestModel) GetPending(chatroom string) ([]*JoinRequest, error) {
+	stmt := `SELECT join_requests.id, join_requests.chatroom, join_requests.user, users.username, join_requests.note, join_requests.created
+	FROM join_requests JOIN users ON users.email = join_requests.user
+	WHERE join_requests.chatroom = ? ORDER BY join_requests.created`
+
+	rows, err := m.DB.Query(stmt, chatroom)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []*JoinRequest{}
+
+	for rows.Next() {
+		j := &JoinRequest{}
+		if err := rows.Scan(&j.ID, &j.Chatroom, &j.User, &j.Username, &j.Note, &j.Created); err != nil {
+			return nil, err
+		}
+		requests = append(requests, j)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
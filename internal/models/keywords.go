@@ -0,0 +1,159 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type Keyword struct {
+	ID      int
+	User    string
+	Keyword string
+	Created time.Time
+}
+
+type KeywordModel struct {
+	DB *sql.DB
+}
+
+func (m *KeywordModel) Insert(user, keyword string) error {
+	stmt := `INSERT INTO keywords (user, keyword, created) VALUES (?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, user, keyword)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "keywords_uc_user_keyword") {
+				return ErrDuplicateKeyword
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m *KeywordModel) Delete(id int, user string) error {
+	stmt := `DELETE FROM keywords WHERE id=? AND user=?`
+
+	_, err := m.DB.Exec(stmt, id, user)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *KeywordModel) GetAll(user string) ([]*Keyword, error) {
+	stmt := `SELECT id, user, keyword, created FROM keywords WHERE user=? ORDER BY keyword`
+
+	rows, err := m.DB.Query(stmt, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keywords := []*Keyword{}
+
+	for rows.Next() {
+		k := &Keyword{}
+		if err := rows.Scan(&k.ID, &k.User, &k.Keyword, &k.Created); err != nil {
+			return nil, err
+		}
+		keywords = append(keywords, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keywords, nil
+}
+
+// GetForChatroom returns the keyword subscriptions of every member of the chatroom
+func (m *KeywordModel) GetForChatroom(chatroom string) ([]*Keyword, error) {
+	stmt := `SELECT keywords.id, keywords.user, keywords.keyword, keywords.created FROM keywords
+	INNER JOIN chatrooms ON chatrooms.user=keywords.user AND chatrooms.name=?`
+
+	rows, err := m.DB.Query(stmt, chatroom)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keywords := []*Keyword{}
+
+	for rows.Next() {
+		k := &Keyword{}
+		if err := rows.Scan(&k.ID, &k.User, &k.Keyword, &k.Created); err != nil {
+			return nil, err
+		}
+		keywords = append(keywords, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keywords, nil
+}
+
+type Alert struct {
+	ID       int
+	ChatID   int
+	Keyword  string
+	Chatroom string
+	Sender   string
+	Username string
+	Message  string
+	Created  time.Time
+}
+
+type AlertModel struct {
+	DB *sql.DB
+}
+
+func (m *AlertModel) Insert(chatID int, user, keyword string) error {
+	stmt := `INSERT INTO alerts (chat_id, user, keyword, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, chatID, user, keyword)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRecent returns the latest keyword alerts raised for the user, newest first
+func (m *AlertModel) GetRecent(user string) ([]*Alert, error) {
+	stmt := `SELECT alerts.id, chats.id, alerts.keyword, chats.chatroom, chats.sender, chats.username, chats.message, chats.created
+	FROM alerts INNER JOIN chats ON chats.id = alerts.chat_id
+	WHERE alerts.user = ? ORDER BY chats.created DESC LIMIT 50`
+
+	rows, err := m.DB.Query(stmt, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []*Alert{}
+
+	for rows.Next() {
+		a := &Alert{}
+		err := rows.Scan(&a.ID, &a.ChatID, &a.Keyword, &a.Chatroom, &a.Sender, &a.Username, &a.Message, &a.Created)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Mention struct {
+	ID       int
+	ChatID   int
+	Chatroom string
+	Sender   string
+	Username string
+	Message  string
+	Created  time.Time
+}
+
+type MentionModel struct {
+	DB *sql.DB
+}
+
+func (m *MentionModel) Insert(chatID int, user string) error {
+	stmt := `INSERT INTO mentions (chat_id, user, created) VALUES (?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, chatID, user)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRecent returns the latest messages that mentioned the user, newest first
+func (m *MentionModel) GetRecent(user string) ([]*Mention, error) {
+	stmt := `SELECT mentions.id, chats.id, chats.chatroom, chats.sender, chats.username, chats.message, chats.created
+	FROM mentions INNER JOIN chats ON chats.id = mentions.chat_id
+	WHERE mentions.user = ? ORDER BY chats.created DESC LIMIT 50`
+
+	rows, err := m.DB.Query(stmt, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []*Mention{}
+
+	for rows.Next() {
+		mt := &Mention{}
+		err := rows.Scan(&mt.ID, &mt.ChatID, &mt.Chatroom, &mt.Sender, &mt.Username, &mt.Message, &mt.Created)
+		if err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, mt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return mentions, nil
+}
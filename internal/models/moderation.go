@@ -0,0 +1,116 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	ActionKick   = "kick"
+	ActionBan    = "ban"
+	ActionUnban  = "unban"
+	ActionMute   = "mute"
+	ActionUnmute = "unmute"
+)
+
+// ModerationAction is one entry in a chatroom's moderation log. A user is
+// banned or muted while the latest ban or mute action against them is
+// unexpired and has not been undone by an unban or unmute.
+type ModerationAction struct {
+	ID       int
+	Chatroom string
+	Target   string
+	Actor    string
+	Action   string
+	Reason   string
+	// Expires is zero when a ban or mute lasts until it is lifted
+	Expires time.Time
+	Created time.Time
+}
+
+type ModerationModel struct {
+	DB *sql.DB
+}
+
+// Insert records an action, zero expires never expires
+func (m *ModerationModel) Insert(chatroom, target, actor, action, reason string, expires time.Time) error {
+	var expiresAt sql.NullTime
+	if !expires.IsZero() {
+		expiresAt = sql.NullTime{Time: expires.UTC(), Valid: true}
+	}
+
+	stmt := `INSERT INTO moderation_actions (chatroom, target, actor, action, reason, expires, created)
+	VALUES (?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, chatroom, target, actor, action, reason, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// active returns the latest of on or off against the user when it is an
+// unexpired on, or nil
+func (m *ModerationModel) active(chatroom, target, on, off string) (*ModerationAction, error) {
+	stmt := `SELECT id, chatroom, target, actor, action, reason, expires, created FROM moderation_actions
+	WHERE chatroom = ? AND target = ? AND action IN (?, ?) ORDER BY id DESC LIMIT 1`
+
+	a := &ModerationAction{}
+	var expires sql.NullTime
+	err := m.DB.QueryRow(stmt, chatroom, target, on, off).Scan(&a.ID, &a.Chatroom, &a.Target, &a.Actor, &a.Action, &a.Reason, &expires, &a.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	a.Expires = expires.Time
+
+	if a.Action != on || (!a.Expires.IsZero() && !time.Now().Before(a.Expires)) {
+		return nil, nil
+	}
+
+	return a, nil
+}
+
+// GetBan returns the ban keeping the user out of the chatroom, or nil
+func (m *ModerationModel) GetBan(chatroom, target string) (*ModerationAction, error) {
+	return m.active(chatroom, target, ActionBan, ActionUnban)
+}
+
+// GetMute returns the mute stopping the user posting in the chatroom, or nil
+func (m *ModerationModel) GetMute(chatroom, target string) (*ModerationAction, error) {
+	return m.active(chatroom, target, ActionMute, ActionUnmute)
+}
+
+// GetLatest returns the chatroom's most recent actions, newest first
+func (m *ModerationModel) GetLatest(chatroom string, limit int) ([]*ModerationAction, error) {
+	stmt := `SELECT id, chatroom, target, actor, action, reason, expires, created FROM moderation_actions
+	WHERE chatroom = ? ORDER BY id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, chatroom, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []*ModerationAction{}
+
+	for rows.Next() {
+		a := &ModerationAction{}
+		var expires sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Chatroom, &a.Target, &a.Actor, &a.Action, &a.Reason, &expires, &a.Created); err != nil {
+			return nil, err
+		}
+		a.Expires = expires.Time
+		actions = append(actions, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Pin struct {
+	ID       int
+	ChatID   int
+	Chatroom string
+	PinnedBy string
+	Sender   string
+	Username string
+	Message  string
+	Created  time.Time
+}
+
+type PinModel struct {
+	DB *sql.DB
+}
+
+// Insert pins the message, pinning an already pinned message does nothing
+func (m *PinModel) Insert(chatID int, chatroom, pinnedBy string) error {
+	stmt := `INSERT IGNORE INTO pins (chat_id, chatroom, pinned_by, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, chatID, chatroom, pinnedBy)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PinModel) Delete(chatID int, chatroom string) error {
+	stmt := `DELETE FROM pins WHERE chat_id=? AND chatroom=?`
+
+	_, err := m.DB.Exec(stmt, chatID, chatroom)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PinModel) GetAll(chatroom string) ([]*Pin, error) {
+	stmt := `SELECT pins.id, chats.id, pins.chatroom, pins.pinned_by, chats.sender, chats.username, chats.message, chats.created
+	FROM pins INNER JOIN chats ON chats.id = pins.chat_id
+	WHERE pins.chatroom = ? ORDER BY pins.created DESC`
+
+	rows, err := m.DB.Query(stmt, chatroom)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := []*Pin{}
+
+	for rows.Next() {
+		p := &Pin{}
+		err := rows.Scan(&p.ID, &p.ChatID, &p.Chatroom, &p.PinnedBy, &p.Sender, &p.Username, &p.Message, &p.Created)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pins, nil
+}
@@ -0,0 +1,304 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"gochat.ayonchakroborty.net/internal/search"
+)
+
+type PollOption struct {
+	ID     int
+	Number int
+	Text   string
+	Votes  int
+	Voters []string
+}
+
+type Poll struct {
+	ID        int
+	ChatID    int
+	Chatroom  string
+	Creator   string
+	Question  string
+	Multiple  bool
+	Anonymous bool
+	// ClosesAt is zero for polls that stay open until closed by hand
+	ClosesAt time.Time
+	Closed   bool
+	Created  time.Time
+	Options  []*PollOption
+}
+
+// IsClosed reports whether the poll has stopped taking votes
+func (p *Poll) IsClosed(now time.Time) bool {
+	return p.Closed || (!p.ClosesAt.IsZero() && !now.Before(p.ClosesAt))
+}
+
+type PollModel struct {
+	DB *sql.DB
+	// Index is fed the chats row each poll is posted as
+	Index search.Indexer
+}
+
+// Insert posts the poll's question as a message in the chatroom and saves the
+// poll with its options, returning the new poll's id
+func (m *PollModel) Insert(chatroom, creator, username, question string, options []string, multiple, anonymous bool, closesAt time.Time) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO chats (chatroom, sender, message, created, username)
+	VALUES (?, ?, ?, UTC_TIMESTAMP(), ?)`
+
+	result, err := tx.Exec(stmt, chatroom, creator, "Poll: "+question, username)
+	if err != nil {
+		return 0, err
+	}
+
+	chatID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	var closes sql.NullTime
+	if !closesAt.IsZero() {
+		closes = sql.NullTime{Time: closesAt.UTC(), Valid: true}
+	}
+
+	stmt = `INSERT INTO polls (chat_id, chatroom, creator, question, multiple, anonymous, closes_at, closed, created)
+	VALUES (?, ?, ?, ?, ?, ?, ?, FALSE, UTC_TIMESTAMP())`
+
+	result, err = tx.Exec(stmt, chatID, chatroom, creator, question, multiple, anonymous, closes)
+	if err != nil {
+		return 0, err
+	}
+
+	pollID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `INSERT INTO poll_options (poll_id, position, text) VALUES (?, ?, ?)`
+
+	for i, option := range options {
+		if _, err := tx.Exec(stmt, pollID, i+1, option); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	chat := &Chat{ID: int(chatID), Chatroom: chatroom, Sender: creator, Message: "Poll: " + question, Username: username}
+	if err := indexChat(m.Index, chat); err != nil {
+		return int(pollID), err
+	}
+
+	return int(pollID), nil
+}
+
+// Get returns the poll with its options in order and their current tallies
+func (m *PollModel) Get(id int) (*Poll, error) {
+	polls, err := m.load(`id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(polls) == 0 {
+		return nil, ErrNoRecord
+	}
+
+	return polls[0], nil
+}
+
+// GetForChats returns the polls posted as any of the given messages keyed by
+// their message id
+func (m *PollModel) GetForChats(chatIDs []int) (map[int]*Poll, error) {
+	polls := map[int]*Poll{}
+	if len(chatIDs) == 0 {
+		return polls, nil
+	}
+
+	args := []any{}
+	for _, id := range chatIDs {
+		args = append(args, id)
+	}
+
+	loaded, err := m.load(`chat_id IN (?`+strings.Repeat(", ?", len(chatIDs)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range loaded {
+		polls[p.ChatID] = p
+	}
+
+	return polls, nil
+}
+
+// load returns the polls matching where with their options in order and
+// their current tallies, reading every poll's options and votes at once
+func (m *PollModel) load(where string, args ...any) ([]*Poll, error) {
+	stmt := `SELECT id, chat_id, chatroom, creator, question, multiple, anonymous, closes_at, closed, created
+	FROM polls WHERE ` + where + ` ORDER BY id`
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	polls := []*Poll{}
+	byID := map[int]*Poll{}
+	pollArgs := []any{}
+
+	for rows.Next() {
+		p := &Poll{}
+		var closesAt sql.NullTime
+		err := rows.Scan(&p.ID, &p.ChatID, &p.Chatroom, &p.Creator, &p.Question,
+			&p.Multiple, &p.Anonymous, &closesAt, &p.Closed, &p.Created)
+		if err != nil {
+			return nil, err
+		}
+		p.ClosesAt = closesAt.Time
+
+		polls = append(polls, p)
+		byID[p.ID] = p
+		pollArgs = append(pollArgs, p.ID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(polls) == 0 {
+		return polls, nil
+	}
+
+	in := `(?` + strings.Repeat(", ?", len(polls)-1) + `)`
+
+	stmt = `SELECT id, poll_id, position, text FROM poll_options WHERE poll_id IN ` + in + ` ORDER BY poll_id, position`
+
+	optionRows, err := m.DB.Query(stmt, pollArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer optionRows.Close()
+
+	options := map[int]*PollOption{}
+
+	for optionRows.Next() {
+		o := &PollOption{Voters: []string{}}
+		var pollID int
+		if err := optionRows.Scan(&o.ID, &pollID, &o.Number, &o.Text); err != nil {
+			return nil, err
+		}
+		p := byID[pollID]
+		p.Options = append(p.Options, o)
+		options[o.ID] = o
+	}
+
+	if err = optionRows.Err(); err != nil {
+		return nil, err
+	}
+
+	stmt = `SELECT poll_votes.poll_id, poll_votes.option_id, COALESCE(users.username, poll_votes.user) FROM poll_votes
+	LEFT JOIN users ON users.email = poll_votes.user
+	WHERE poll_votes.poll_id IN ` + in + ` ORDER BY poll_votes.created`
+
+	votes, err := m.DB.Query(stmt, pollArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer votes.Close()
+
+	for votes.Next() {
+		var pollID, optionID int
+		var voter string
+		if err := votes.Scan(&pollID, &optionID, &voter); err != nil {
+			return nil, err
+		}
+
+		if o, ok := options[optionID]; ok {
+			o.Votes++
+			if !byID[pollID].Anonymous {
+				o.Voters = append(o.Voters, voter)
+			}
+		}
+	}
+
+	if err = votes.Err(); err != nil {
+		return nil, err
+	}
+
+	return polls, nil
+}
+
+// GetDue returns the ids of open polls whose close time has passed
+func (m *PollModel) GetDue() ([]int, error) {
+	return m.ids(`SELECT id FROM polls WHERE closed = FALSE AND closes_at <= UTC_TIMESTAMP()`)
+}
+
+// Vote replaces the user's votes in the poll with optionIDs
+func (m *PollModel) Vote(pollID int, user string, optionIDs []int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `DELETE FROM poll_votes WHERE poll_id = ? AND user = ?`
+
+	if _, err := tx.Exec(stmt, pollID, user); err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO poll_votes (poll_id, option_id, user, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+	for _, optionID := range optionIDs {
+		if _, err := tx.Exec(stmt, pollID, optionID, user); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *PollModel) Close(id int) error {
+	stmt := `UPDATE polls SET closed = TRUE WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PollModel) ids(stmt string, args ...any) ([]int, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
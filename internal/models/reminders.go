@@ -0,0 +1,96 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Reminder struct {
+	ID       int
+	User     string
+	Username string
+	// Chatroom is empty for reminders only the user should see
+	Chatroom string
+	Message  string
+	RemindAt time.Time
+	Created  time.Time
+}
+
+type ReminderModel struct {
+	DB *sql.DB
+}
+
+func (m *ReminderModel) Insert(user, username, chatroom, message string, remindAt time.Time) (int, error) {
+	stmt := `INSERT INTO reminders (user, username, chatroom, message, remind_at, created)
+	VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, user, username, chatroom, message, remindAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Delete removes the user's reminder and reports whether there was one to remove
+func (m *ReminderModel) Delete(id int, user string) (bool, error) {
+	stmt := `DELETE FROM reminders WHERE id=? AND user=?`
+
+	result, err := m.DB.Exec(stmt, id, user)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// GetPending returns the user's reminders that have not fired yet, soonest first
+func (m *ReminderModel) GetPending(user string) ([]*Reminder, error) {
+	stmt := `SELECT id, user, username, chatroom, message, remind_at, created FROM reminders
+	WHERE user = ? ORDER BY remind_at ASC`
+
+	return m.query(stmt, user)
+}
+
+// GetDue returns every reminder whose time has passed
+func (m *ReminderModel) GetDue() ([]*Reminder, error) {
+	stmt := `SELECT id, user, username, chatroom, message, remind_at, created FROM reminders
+	WHERE remind_at <= UTC_TIMESTAMP() ORDER BY remind_at ASC`
+
+	return m.query(stmt)
+}
+
+func (m *ReminderModel) query(stmt string, args ...any) ([]*Reminder, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []*Reminder{}
+
+	for rows.Next() {
+		r := &Reminder{}
+		err := rows.Scan(&r.ID, &r.User, &r.Username, &r.Chatroom, &r.Message, &r.RemindAt, &r.Created)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reminders, nil
+}
@@ -0,0 +1,436 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Room holds the settings shared by everyone in a chatroom, chatrooms has one
+// row per member so they cannot live there
+type Room struct {
+	Name        string
+	Topic       string
+	Description string
+	// AvatarUpdated is when the room's avatar was last changed, zero if it has none
+	AvatarUpdated time.Time
+	// AvatarHash identifies the avatar image's content
+	AvatarHash string
+	// Archived is when the room was archived, zero while it is active.
+	// Archived rooms are read only.
+	Archived time.Time
+	// Announcement rooms only let moderators post, everyone else reads
+	Announcement bool
+	// AutoJoin adds every new user to the room when they sign up
+	AutoJoin bool
+	// SlowMode is the least time members must leave between messages, zero when off
+	SlowMode time.Duration
+	// JoinPolicy is JoinOpen, JoinKnock or JoinInvite
+	JoinPolicy string
+	// DisappearAfter is how long messages are kept before being deleted, zero keeps them
+	DisappearAfter time.Duration
+	// DisappearSince is when disappearing messages were turned on, only
+	// messages sent after it disappear. Zero in rooms that had them before
+	// the time was recorded.
+	DisappearSince time.Time
+	// RetentionDays overrides the global retention policy when RetentionOverride
+	// is set, zero keeps messages forever
+	RetentionDays     int
+	RetentionOverride bool
+	Updated           time.Time
+}
+
+const (
+	// JoinOpen rooms can be joined by anyone who knows their name
+	JoinOpen = "open"
+	// JoinKnock rooms can be asked to join, a moderator lets people in
+	JoinKnock = "knock"
+	// JoinInvite rooms can only be joined with an invitation or invite link
+	JoinInvite = "invite"
+)
+
+// AvatarURL is where the room's avatar is served, it changes with the avatar
+// so browsers can cache it. Rooms without an avatar have no URL.
+func (r *Room) AvatarURL() string {
+	if r.AvatarUpdated.IsZero() {
+		return ""
+	}
+
+	return fmt.Sprintf("/chat/room/%s/avatar?v=%s", url.PathEscape(r.Name), r.AvatarHash)
+}
+
+type RoomModel struct {
+	DB *sql.DB
+}
+
+// roomColumns are the columns scanned by scanRoom
+const roomColumns = `name, topic, description, join_policy, disappear_after, disappear_since, retention_days, avatar_updated, avatar_hash, archived,
+	announcement, auto_join, slow_mode, updated`
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRoom(row scanner) (*Room, error) {
+	room := &Room{}
+	var disappearAfter, slowMode int64
+	var retentionDays sql.NullInt64
+	var disappearSince, avatarUpdated, archived sql.NullTime
+	err := row.Scan(&room.Name, &room.Topic, &room.Description, &room.JoinPolicy, &disappearAfter, &disappearSince, &retentionDays, &avatarUpdated, &room.AvatarHash, &archived,
+		&room.Announcement, &room.AutoJoin, &slowMode, &room.Updated)
+	if err != nil {
+		return nil, err
+	}
+	room.DisappearAfter, room.DisappearSince = time.Duration(disappearAfter)*time.Second, disappearSince.Time
+	room.RetentionDays, room.RetentionOverride = int(retentionDays.Int64), retentionDays.Valid
+	room.AvatarUpdated, room.Archived = avatarUpdated.Time, archived.Time
+	room.SlowMode = time.Duration(slowMode) * time.Second
+
+	return room, nil
+}
+
+// Get returns the room's settings, rooms nobody has configured get the defaults
+func (m *RoomModel) Get(name string) (*Room, error) {
+	stmt := `SELECT ` + roomColumns + ` FROM rooms WHERE name = ?`
+
+	room, err := scanRoom(m.DB.QueryRow(stmt, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &Room{Name: name, JoinPolicy: JoinOpen}, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return room, nil
+}
+
+// GetMany returns the settings of each of the named rooms keyed by name,
+// rooms nobody has configured get the defaults
+func (m *RoomModel) GetMany(names []string) (map[string]*Room, error) {
+	rooms := map[string]*Room{}
+	if len(names) == 0 {
+		return rooms, nil
+	}
+
+	stmt := `SELECT ` + roomColumns + ` FROM rooms WHERE name IN (?` + strings.Repeat(", ?", len(names)-1) + `)`
+	args := []any{}
+	for _, name := range names {
+		args = append(args, name)
+	}
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms[room.Name] = room
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if _, ok := rooms[name]; !ok {
+			rooms[name] = &Room{Name: name, JoinPolicy: JoinOpen}
+		}
+	}
+
+	return rooms, nil
+}
+
+func (m *RoomModel) SetTopic(name, topic string) error {
+	stmt := `INSERT INTO rooms (name, topic, updated) VALUES (?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE topic = VALUES(topic), updated = VALUES(updated)`
+
+	_, err := m.DB.Exec(stmt, name, topic)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetDetails changes the room's topic and description together
+func (m *RoomModel) SetDetails(name, topic, description string) error {
+	stmt := `INSERT INTO rooms (name, topic, description, updated) VALUES (?, ?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE topic = VALUES(topic), description = VALUES(description), updated = VALUES(updated)`
+
+	_, err := m.DB.Exec(stmt, name, topic, description)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetAvatar replaces the room's avatar image. Its hash goes in the avatar's
+// URL so a new image always gets a new URL.
+func (m *RoomModel) SetAvatar(name, contentType string, image []byte) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO room_avatars (name, content_type, image) VALUES (?, ?, ?)
+	ON DUPLICATE KEY UPDATE content_type = VALUES(content_type), image = VALUES(image)`
+
+	if _, err := tx.Exec(stmt, name, contentType, image); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(image)
+	hash := hex.EncodeToString(sum[:8])
+
+	stmt = `INSERT INTO rooms (name, avatar_updated, avatar_hash, updated) VALUES (?, UTC_TIMESTAMP(), ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE avatar_updated = VALUES(avatar_updated), avatar_hash = VALUES(avatar_hash), updated = VALUES(updated)`
+
+	if _, err := tx.Exec(stmt, name, hash); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteAvatar removes the room's avatar image
+func (m *RoomModel) DeleteAvatar(name string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `DELETE FROM room_avatars WHERE name = ?`
+
+	if _, err := tx.Exec(stmt, name); err != nil {
+		return err
+	}
+
+	stmt = `UPDATE rooms SET avatar_updated = NULL, avatar_hash = '', updated = UTC_TIMESTAMP() WHERE name = ?`
+
+	if _, err := tx.Exec(stmt, name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAvatar returns the room's avatar image and its content type
+func (m *RoomModel) GetAvatar(name string) (string, []byte, error) {
+	stmt := `SELECT content_type, image FROM room_avatars WHERE name = ?`
+
+	var contentType string
+	var image []byte
+	err := m.DB.QueryRow(stmt, name).Scan(&contentType, &image)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, ErrNoRecord
+		} else {
+			return "", nil, err
+		}
+	}
+
+	return contentType, image, nil
+}
+
+// SetArchived archives the room or makes it active again
+func (m *RoomModel) SetArchived(name string, archived bool) error {
+	stmt := `INSERT INTO rooms (name, archived, updated) VALUES (?, IF(?, UTC_TIMESTAMP(), NULL), UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE archived = VALUES(archived), updated = VALUES(updated)`
+
+	_, err := m.DB.Exec(stmt, name, archived)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetAnnouncement turns announcement mode on or off, turning it off also stops
+// new users joining the room automatically
+func (m *RoomModel) SetAnnouncement(name string, announcement bool) error {
+	stmt := `INSERT INTO rooms (name, announcement, updated) VALUES (?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE announcement = VALUES(announcement), auto_join = auto_join AND VALUES(announcement),
+	updated = VALUES(updated)`
+
+	_, err := m.DB.Exec(stmt, name, announcement)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *RoomModel) SetAutoJoin(name string, autoJoin bool) error {
+	stmt := `INSERT INTO rooms (name, auto_join, updated) VALUES (?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE auto_join = VALUES(auto_join), updated = VALUES(updated)`
+
+	_, err := m.DB.Exec(stmt, name, autoJoin)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAutoJoin returns the names of the active rooms new users are added to
+func (m *RoomModel) GetAutoJoin() ([]string, error) {
+	stmt := `SELECT name FROM rooms WHERE auto_join = TRUE AND archived IS NULL`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+func (m *RoomModel) SetSlowMode(name string, interval time.Duration) error {
+	stmt := `INSERT INTO rooms (name, slow_mode, updated) VALUES (?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE slow_mode = VALUES(slow_mode), updated = VALUES(updated)`
+
+	_, err := m.DB.Exec(stmt, name, int64(interval/time.Second))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *RoomModel) SetJoinPolicy(name, policy string) error {
+	stmt := `INSERT INTO rooms (name, join_policy, updated) VALUES (?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE join_policy = VALUES(join_policy), updated = VALUES(updated)`
+
+	_, err := m.DB.Exec(stmt, name, policy)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetDisappearAfter sets the room's disappearing message timer. Turning it on
+// records the time so messages sent before then are kept, changing the timer
+// while it is on keeps the original time.
+func (m *RoomModel) SetDisappearAfter(name string, after time.Duration) error {
+	stmt := `INSERT INTO rooms (name, disappear_after, disappear_since, updated)
+	VALUES (?, ?, IF(? > 0, UTC_TIMESTAMP(), NULL), UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE
+	disappear_since = IF(VALUES(disappear_after) = 0, NULL, IF(disappear_after > 0, disappear_since, UTC_TIMESTAMP())),
+	disappear_after = VALUES(disappear_after), updated = VALUES(updated)`
+
+	seconds := int64(after / time.Second)
+	_, err := m.DB.Exec(stmt, name, seconds, seconds)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetDisappearing returns every room with disappearing messages turned on
+func (m *RoomModel) GetDisappearing() ([]*Room, error) {
+	stmt := `SELECT name, topic, disappear_after, disappear_since, updated FROM rooms WHERE disappear_after > 0`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []*Room{}
+
+	for rows.Next() {
+		room := &Room{}
+		var disappearAfter int64
+		var disappearSince sql.NullTime
+		if err := rows.Scan(&room.Name, &room.Topic, &disappearAfter, &disappearSince, &room.Updated); err != nil {
+			return nil, err
+		}
+		room.DisappearAfter, room.DisappearSince = time.Duration(disappearAfter)*time.Second, disappearSince.Time
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rooms, nil
+}
+
+// SetRetention overrides the global retention policy for the room, a negative
+// days value goes back to the global policy
+func (m *RoomModel) SetRetention(name string, days int) error {
+	var retentionDays sql.NullInt64
+	if days >= 0 {
+		retentionDays = sql.NullInt64{Int64: int64(days), Valid: true}
+	}
+
+	stmt := `INSERT INTO rooms (name, retention_days, updated) VALUES (?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE retention_days = VALUES(retention_days), updated = VALUES(updated)`
+
+	_, err := m.DB.Exec(stmt, name, retentionDays)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRetentionOverrides returns the rooms that override the global retention
+// policy mapped to how many days they keep messages for
+func (m *RoomModel) GetRetentionOverrides() (map[string]int, error) {
+	stmt := `SELECT name, retention_days FROM rooms WHERE retention_days IS NOT NULL`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := map[string]int{}
+
+	for rows.Next() {
+		var name string
+		var days int
+		if err := rows.Scan(&name, &days); err != nil {
+			return nil, err
+		}
+		overrides[name] = days
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return overrides, nil
+}
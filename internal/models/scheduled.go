@@ -0,0 +1,150 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type ScheduledMessage struct {
+	ID       int
+	Chatroom string
+	Sender   string
+	Username string
+	Message  string
+	SendAt   time.Time
+	Created  time.Time
+}
+
+type ScheduledMessageModel struct {
+	DB *sql.DB
+}
+
+func (m *ScheduledMessageModel) Insert(chatroom, sender, username, message string, sendAt time.Time) (int, error) {
+	stmt := `INSERT INTO scheduled_messages (chatroom, sender, username, message, send_at, created)
+	VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, chatroom, sender, username, message, sendAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (m *ScheduledMessageModel) Get(id int, sender string) (*ScheduledMessage, error) {
+	stmt := `SELECT id, chatroom, sender, username, message, send_at, created FROM scheduled_messages
+	WHERE id = ? AND sender = ?`
+
+	s := &ScheduledMessage{}
+	err := m.DB.QueryRow(stmt, id, sender).Scan(&s.ID, &s.Chatroom, &s.Sender, &s.Username, &s.Message, &s.SendAt, &s.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Update changes a message that has not been sent yet, returning ErrNoRecord
+// if it has already gone out or been cancelled
+func (m *ScheduledMessageModel) Update(id int, sender, message string, sendAt time.Time) error {
+	stmt := `UPDATE scheduled_messages SET message=?, send_at=? WHERE id=? AND sender=?`
+
+	result, err := m.DB.Exec(stmt, message, sendAt.UTC(), id, sender)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// MySQL does not count a row that was saved unchanged, so check it is still there
+	var exists bool
+	stmt = `SELECT EXISTS(SELECT true FROM scheduled_messages WHERE id = ? AND sender = ?)`
+
+	if err := m.DB.QueryRow(stmt, id, sender).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Delete removes a message that has not been sent yet, returning ErrNoRecord
+// if it is already gone. The scheduler relies on this to claim each message
+// exactly once.
+func (m *ScheduledMessageModel) Delete(id int, sender string) error {
+	stmt := `DELETE FROM scheduled_messages WHERE id=? AND sender=?`
+
+	result, err := m.DB.Exec(stmt, id, sender)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// GetPending returns the messages the sender has scheduled, soonest first
+func (m *ScheduledMessageModel) GetPending(sender string) ([]*ScheduledMessage, error) {
+	stmt := `SELECT id, chatroom, sender, username, message, send_at, created FROM scheduled_messages
+	WHERE sender = ? ORDER BY send_at ASC`
+
+	return m.query(stmt, sender)
+}
+
+// GetDue returns every scheduled message whose send time has passed
+func (m *ScheduledMessageModel) GetDue() ([]*ScheduledMessage, error) {
+	stmt := `SELECT id, chatroom, sender, username, message, send_at, created FROM scheduled_messages
+	WHERE send_at <= UTC_TIMESTAMP() ORDER BY send_at ASC`
+
+	return m.query(stmt)
+}
+
+func (m *ScheduledMessageModel) query(stmt string, args ...any) ([]*ScheduledMessage, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*ScheduledMessage{}
+
+	for rows.Next() {
+		s := &ScheduledMessage{}
+		err := rows.Scan(&s.ID, &s.Chatroom, &s.Sender, &s.Username, &s.Message, &s.SendAt, &s.Created)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
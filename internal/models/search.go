@@ -0,0 +1,176 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+type SearchResult struct {
+	Chat
+	Score float64
+}
+
+// GetByIDs returns the messages with the given ids that still exist, keyed by id
+func (m *ChatModel) GetByIDs(ids []int) (map[int]*Chat, error) {
+	chats := map[int]*Chat{}
+	if len(ids) == 0 {
+		return chats, nil
+	}
+
+	stmt := `SELECT chats.id, chats.chatroom, chats.sender, chats.message, chats.created, chats.username,
+	forwards.chatroom, forwards.username, forwards.created
+	FROM chats LEFT JOIN forwards ON forwards.chat_id = chats.id
+	WHERE chats.id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+
+	args := []any{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := &Chat{}
+		var from, author sql.NullString
+		var created sql.NullTime
+		err := rows.Scan(&c.ID, &c.Chatroom, &c.Sender, &c.Message, &c.Created, &c.Username, &from, &author, &created)
+		if err != nil {
+			return nil, err
+		}
+		c.ForwardedFrom, c.ForwardedAuthor, c.ForwardedCreated = from.String, author.String, created.Time
+		chats[c.ID] = c
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chats, nil
+}
+
+// GetBatchAfter returns up to limit messages with ids after the given id in
+// id order, for walking the whole table
+func (m *ChatModel) GetBatchAfter(id, limit int) ([]*Chat, error) {
+	stmt := `SELECT id, chatroom, sender, message, created, username FROM chats
+	WHERE id > ? ORDER BY id LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []*Chat{}
+
+	for rows.Next() {
+		c := &Chat{}
+		if err := rows.Scan(&c.ID, &c.Chatroom, &c.Sender, &c.Message, &c.Created, &c.Username); err != nil {
+			return nil, err
+		}
+		chats = append(chats, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chats, nil
+}
+
+// GetRoomBatchAfter returns up to limit of the chatroom's messages with ids
+// after the given id in id order, for walking its whole history
+func (m *ChatModel) GetRoomBatchAfter(chatroom string, id, limit int) ([]*Chat, error) {
+	stmt := `SELECT id, chatroom, sender, message, created, username FROM chats
+	WHERE chatroom = ? AND id > ? ORDER BY id LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, chatroom, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []*Chat{}
+
+	for rows.Next() {
+		c := &Chat{}
+		if err := rows.Scan(&c.ID, &c.Chatroom, &c.Sender, &c.Message, &c.Created, &c.Username); err != nil {
+			return nil, err
+		}
+		chats = append(chats, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chats, nil
+}
+
+// Reindex rebuilds the search index from every message in chats
+func (m *ChatModel) Reindex() (int, error) {
+	if m.Index == nil {
+		return 0, nil
+	}
+
+	if err := m.Index.Reset(); err != nil {
+		return 0, err
+	}
+
+	count, last := 0, 0
+	for {
+		chats, err := m.GetBatchAfter(last, 1000)
+		if err != nil {
+			return count, err
+		}
+
+		for _, c := range chats {
+			if err := indexChat(m.Index, c); err != nil {
+				return count, err
+			}
+			last = c.ID
+		}
+		count += len(chats)
+
+		if len(chats) < 1000 {
+			return count, nil
+		}
+	}
+}
+
+// GetContext returns the message with the given id along with up to n
+// messages either side of it in the same chatroom, oldest first
+func (m *ChatModel) GetContext(chat *Chat, n int) ([]*Chat, error) {
+	stmt := `SELECT * FROM (
+		(SELECT id, chatroom, sender, message, created, username FROM chats
+		WHERE chatroom = ? AND id <= ? ORDER BY id DESC LIMIT ?)
+		UNION ALL
+		(SELECT id, chatroom, sender, message, created, username FROM chats
+		WHERE chatroom = ? AND id > ? ORDER BY id ASC LIMIT ?)
+	) AS context ORDER BY id ASC`
+
+	rows, err := m.DB.Query(stmt, chat.Chatroom, chat.ID, n+1, chat.Chatroom, chat.ID, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []*Chat{}
+
+	for rows.Next() {
+		c := &Chat{}
+		if err := rows.Scan(&c.ID, &c.Chatroom, &c.Sender, &c.Message, &c.Created, &c.Username); err != nil {
+			return nil, err
+		}
+		chats = append(chats, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chats, nil
+}
@@ -146,13 +146,86 @@ func (m *UserModel) EmailExists(email string) (bool, error) {
 	return exists, err
 }
 
+func (m *UserModel) IsAdmin(email string) (bool, error) {
+	var admin bool
+
+	stmt := "SELECT EXISTS(SELECT true FROM users WHERE email = ? AND admin = TRUE)"
+	err := m.DB.QueryRow(stmt, email).Scan(&admin)
+
+	return admin, err
+}
+
+// Who a user lets start private chatrooms with them
+const (
+	DMEveryone = "everyone"
+	DMShared   = "shared"
+	DMContacts = "contacts"
+	DMNobody   = "nobody"
+)
+
+// GetDMPolicy returns who the user lets start private chatrooms with them,
+// ErrNoRecord is returned if there is no such user
+func (m *UserModel) GetDMPolicy(email string) (string, error) {
+	var policy string
+
+	stmt := `SELECT dm_policy FROM users WHERE email = ?`
+	err := m.DB.QueryRow(stmt, email).Scan(&policy)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+
+	return policy, nil
+}
+
+func (m *UserModel) SetDMPolicy(email, policy string) error {
+	stmt := `UPDATE users SET dm_policy = ? WHERE email = ?`
+
+	_, err := m.DB.Exec(stmt, policy, email)
+
+	return err
+}
+
+// DeleteUser deletes the user's account, users under a legal hold cannot be
+// deleted and the attempt is audited
 func (m *UserModel) DeleteUser(email string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	held, err := isHeld(tx, HoldUser, email)
+	if err != nil {
+		return err
+	}
+
+	if held {
+		if err := recordBlocked(tx, "user_deletion", HoldUser, email, email, 1); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrLegalHold
+	}
+
 	stmt := `DELETE FROM users WHERE email=?`
 
-	_, err := m.DB.Exec(stmt, email)
+	_, err = tx.Exec(stmt, email)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	stmt = `DELETE FROM contacts WHERE user=? OR contact=?`
+
+	_, err = tx.Exec(stmt, email, email)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
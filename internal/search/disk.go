@@ -0,0 +1,603 @@
+package search
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	snapshotFile = "index.gob"
+	// logPattern names each log by its generation, a snapshot holds every
+	// write logged before its own generation
+	logPattern = "index.%d.log"
+
+	// compactAfter is how many logged writes are replayed on open at most
+	// before the index is written out as a fresh snapshot
+	compactAfter = 10000
+)
+
+// postings maps a document id to the positions of a term within it
+type postings map[int][]int
+
+type docMeta struct {
+	Chatroom string
+	Sender   string
+	Username string
+	Created  int64
+	Terms    []string
+}
+
+type snapshot struct {
+	// Gen is the first log generation not included in the snapshot
+	Gen      int
+	Docs     map[int]docMeta
+	Postings map[string]postings
+}
+
+type logEntry struct {
+	Op   string    `json:"op"`
+	Doc  *Document `json:"doc,omitempty"`
+	ID   int       `json:"id,omitempty"`
+	From string    `json:"from,omitempty"`
+	To   string    `json:"to,omitempty"`
+}
+
+// DiskIndex is an inverted index held in memory and persisted to a directory
+// as a snapshot plus logs of the writes made since it was taken. Snapshots
+// are written in the background from a copy so writes are not held up.
+type DiskIndex struct {
+	mu       sync.RWMutex
+	dir      string
+	docs     map[int]docMeta
+	postings map[string]postings
+	// terms is every indexed term sorted for prefix lookups, nil when stale
+	terms  []string
+	log    *os.File
+	gen    int
+	logged int
+	// compacting is set while a snapshot is being written in the background,
+	// compactErr keeps the last one that failed for Close to report
+	compacting  bool
+	compactErr  error
+	compactions sync.WaitGroup
+
+	// snapMu orders snapshot writes, snapGen is the generation of the
+	// snapshot on disk
+	snapMu  sync.Mutex
+	snapGen int
+}
+
+// OpenDiskIndex loads the index stored in dir, creating an empty one if dir
+// does not hold an index yet
+func OpenDiskIndex(dir string) (*DiskIndex, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	d := &DiskIndex{
+		dir:      dir,
+		docs:     map[int]docMeta{},
+		postings: map[string]postings{},
+	}
+
+	f, err := os.Open(filepath.Join(dir, snapshotFile))
+	switch {
+	case err == nil:
+		s := snapshot{}
+		err = gob.NewDecoder(f).Decode(&s)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("search: corrupt snapshot: %v", err)
+		}
+		if s.Docs != nil {
+			d.docs, d.postings = s.Docs, s.Postings
+		}
+		d.snapGen = s.Gen
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	gens, err := d.logGens()
+	if err != nil {
+		return nil, err
+	}
+
+	d.gen = d.snapGen
+	for _, gen := range gens {
+		// logs older than the snapshot are left over from a compaction that
+		// stopped before cleaning up
+		if gen < d.snapGen {
+			os.Remove(d.logPath(gen))
+			continue
+		}
+		if err := d.replay(d.logPath(gen)); err != nil {
+			return nil, err
+		}
+		d.gen = gen
+	}
+
+	d.log, err = os.OpenFile(d.logPath(d.gen), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *DiskIndex) logPath(gen int) string {
+	return filepath.Join(d.dir, fmt.Sprintf(logPattern, gen))
+}
+
+// logGens returns the generation of every log in the directory in order
+func (d *DiskIndex) logGens() ([]int, error) {
+	paths, err := filepath.Glob(filepath.Join(d.dir, "index.*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	gens := []int{}
+	for _, path := range paths {
+		var gen int
+		if _, err := fmt.Sscanf(filepath.Base(path), logPattern, &gen); err == nil {
+			gens = append(gens, gen)
+		}
+	}
+	sort.Ints(gens)
+
+	return gens, nil
+}
+
+// replay applies the writes logged at path on top of the index. A write cut
+// short by a crash is the last line and is skipped.
+func (d *DiskIndex) replay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		entry := logEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		switch entry.Op {
+		case "index":
+			if entry.Doc != nil {
+				d.add(*entry.Doc)
+			}
+		case "delete":
+			d.remove(entry.ID)
+		case "rename":
+			d.rename(entry.From, entry.To)
+		}
+		d.logged++
+	}
+
+	return scanner.Err()
+}
+
+// Empty reports whether nothing has been indexed, which is the case the
+// first time an index directory is opened
+func (d *DiskIndex) Empty() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return len(d.docs) == 0
+}
+
+func (d *DiskIndex) Index(doc Document) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.write(logEntry{Op: "index", Doc: &doc}); err != nil {
+		return err
+	}
+	d.add(doc)
+
+	return d.maybeCompact()
+}
+
+func (d *DiskIndex) Delete(id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.docs[id]; !ok {
+		return nil
+	}
+
+	if err := d.write(logEntry{Op: "delete", ID: id}); err != nil {
+		return err
+	}
+	d.remove(id)
+
+	return d.maybeCompact()
+}
+
+func (d *DiskIndex) Rename(from, to string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.write(logEntry{Op: "rename", From: from, To: to}); err != nil {
+		return err
+	}
+	d.rename(from, to)
+
+	return d.maybeCompact()
+}
+
+func (d *DiskIndex) Reset() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.docs = map[int]docMeta{}
+	d.postings = map[string]postings{}
+	d.terms = nil
+
+	s, err := d.rotate()
+	if err != nil {
+		return err
+	}
+
+	return d.writeSnapshot(s)
+}
+
+// Close waits for any snapshot being written, then writes out a final one so
+// the next open has no log to replay
+func (d *DiskIndex) Close() error {
+	d.compactions.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.log.Close(); err != nil {
+		return err
+	}
+
+	s := snapshot{Gen: d.gen + 1, Docs: d.docs, Postings: d.postings}
+	if err := d.writeSnapshot(s); err != nil {
+		return err
+	}
+
+	return d.compactErr
+}
+
+func (d *DiskIndex) write(entry logEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.log.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	d.logged++
+
+	return nil
+}
+
+// maybeCompact starts writing a snapshot in the background once enough
+// writes have been logged, callers hold d.mu
+func (d *DiskIndex) maybeCompact() error {
+	if d.logged < compactAfter || d.compacting {
+		return nil
+	}
+
+	s, err := d.rotate()
+	if err != nil {
+		return err
+	}
+
+	d.compacting = true
+	d.compactions.Add(1)
+
+	go func() {
+		defer d.compactions.Done()
+
+		err := d.writeSnapshot(s)
+
+		d.mu.Lock()
+		d.compacting = false
+		if err != nil {
+			d.compactErr = err
+		}
+		d.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// rotate starts logging to a new generation and returns a copy of the index
+// as it stood before it, callers hold d.mu. Only the maps are copied, the
+// position slices and term lists in them are never changed in place.
+func (d *DiskIndex) rotate() (snapshot, error) {
+	log, err := os.OpenFile(d.logPath(d.gen+1), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return snapshot{}, err
+	}
+
+	if err := d.log.Close(); err != nil {
+		log.Close()
+		return snapshot{}, err
+	}
+	d.log = log
+	d.gen++
+	d.logged = 0
+
+	s := snapshot{
+		Gen:      d.gen,
+		Docs:     make(map[int]docMeta, len(d.docs)),
+		Postings: make(map[string]postings, len(d.postings)),
+	}
+	for id, meta := range d.docs {
+		s.Docs[id] = meta
+	}
+	for term, p := range d.postings {
+		c := make(postings, len(p))
+		for id, positions := range p {
+			c[id] = positions
+		}
+		s.Postings[term] = c
+	}
+
+	return s, nil
+}
+
+// writeSnapshot replaces the snapshot with s unless a newer one is already on
+// disk, then removes the logs it covers. The snapshot is renamed into place
+// so a crash leaves the old one and its logs intact.
+func (d *DiskIndex) writeSnapshot(s snapshot) error {
+	d.snapMu.Lock()
+	defer d.snapMu.Unlock()
+
+	if s.Gen <= d.snapGen {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(d.dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = gob.NewEncoder(tmp).Encode(s)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(d.dir, snapshotFile)); err != nil {
+		return err
+	}
+	d.snapGen = s.Gen
+
+	gens, err := d.logGens()
+	if err != nil {
+		return err
+	}
+	for _, gen := range gens {
+		if gen < s.Gen {
+			if err := os.Remove(d.logPath(gen)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *DiskIndex) add(doc Document) {
+	d.remove(doc.ID)
+
+	meta := docMeta{
+		Chatroom: doc.Chatroom,
+		Sender:   doc.Sender,
+		Username: doc.Username,
+		Created:  doc.Created.UnixNano(),
+	}
+
+	for position, term := range tokenize(doc.Message) {
+		p, ok := d.postings[term]
+		if !ok {
+			p = postings{}
+			d.postings[term] = p
+			d.terms = nil
+		}
+
+		if _, ok := p[doc.ID]; !ok {
+			meta.Terms = append(meta.Terms, term)
+		}
+		p[doc.ID] = append(p[doc.ID], position)
+	}
+
+	d.docs[doc.ID] = meta
+}
+
+func (d *DiskIndex) remove(id int) {
+	meta, ok := d.docs[id]
+	if !ok {
+		return
+	}
+
+	for _, term := range meta.Terms {
+		delete(d.postings[term], id)
+		if len(d.postings[term]) == 0 {
+			delete(d.postings, term)
+			d.terms = nil
+		}
+	}
+
+	delete(d.docs, id)
+}
+
+func (d *DiskIndex) rename(from, to string) {
+	for id, meta := range d.docs {
+		if meta.Chatroom == from {
+			meta.Chatroom = to
+			d.docs[id] = meta
+		}
+	}
+}
+
+func (d *DiskIndex) Search(q Query) ([]Hit, error) {
+	clauses := parseQuery(q.Text)
+	if len(clauses) == 0 || len(q.Chatrooms) == 0 {
+		return []Hit{}, nil
+	}
+
+	// prefix lookups need the sorted term list, which needs the write lock to rebuild
+	d.mu.Lock()
+	if d.terms == nil {
+		d.terms = make([]string, 0, len(d.postings))
+		for term := range d.postings {
+			d.terms = append(d.terms, term)
+		}
+		sort.Strings(d.terms)
+	}
+	terms := d.terms
+	d.mu.Unlock()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var scores map[int]float64
+	for _, c := range clauses {
+		matches := d.match(c, terms)
+
+		if scores == nil {
+			scores = matches
+			continue
+		}
+
+		for id := range scores {
+			if score, ok := matches[id]; ok {
+				scores[id] += score
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	allowed := map[string]bool{}
+	for _, chatroom := range q.Chatrooms {
+		allowed[chatroom] = true
+	}
+
+	hits := []Hit{}
+	for id, score := range scores {
+		meta := d.docs[id]
+
+		switch {
+		case !allowed[meta.Chatroom]:
+		case q.Chatroom != "" && meta.Chatroom != q.Chatroom:
+		case q.Sender != "" && meta.Sender != q.Sender && meta.Username != q.Sender:
+		case !q.From.IsZero() && meta.Created < q.From.UnixNano():
+		case !q.To.IsZero() && meta.Created >= q.To.UnixNano():
+		default:
+			hits = append(hits, Hit{ID: id, Score: score})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return d.docs[hits[i].ID].Created > d.docs[hits[j].ID].Created
+	})
+
+	if q.Offset >= len(hits) {
+		return []Hit{}, nil
+	}
+	hits = hits[q.Offset:]
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+
+	return hits, nil
+}
+
+// match scores every document the clause matches by tf-idf, terms is the
+// sorted term list prefixes are looked up in
+func (d *DiskIndex) match(c clause, terms []string) map[int]float64 {
+	scores := map[int]float64{}
+
+	switch c.kind {
+	case clauseTerm:
+		d.score(scores, c.terms[0], d.postings[c.terms[0]])
+
+	case clausePrefix:
+		prefix := c.terms[0]
+		for i := sort.SearchStrings(terms, prefix); i < len(terms) && strings.HasPrefix(terms[i], prefix); i++ {
+			d.score(scores, terms[i], d.postings[terms[i]])
+		}
+
+	case clausePhrase:
+		first := d.postings[c.terms[0]]
+		phrases := postings{}
+
+		for id, positions := range first {
+			for _, position := range positions {
+				if d.phraseAt(c.terms[1:], id, position+1) {
+					phrases[id] = append(phrases[id], position)
+				}
+			}
+		}
+
+		for _, term := range c.terms {
+			d.score(scores, term, phrases)
+		}
+	}
+
+	return scores
+}
+
+// phraseAt reports whether terms appear in order in the document starting at position
+func (d *DiskIndex) phraseAt(terms []string, id, position int) bool {
+	for i, term := range terms {
+		positions := d.postings[term][id]
+		j := sort.SearchInts(positions, position+i)
+		if j == len(positions) || positions[j] != position+i {
+			return false
+		}
+	}
+
+	return true
+}
+
+// score adds term's weight in each of the matched documents, df comes from
+// the term's full postings so rare words count for more
+func (d *DiskIndex) score(scores map[int]float64, term string, matched postings) {
+	df := len(d.postings[term])
+	if df == 0 {
+		return
+	}
+	idf := math.Log(1 + float64(len(d.docs))/float64(df))
+
+	for id, positions := range matched {
+		scores[id] += (1 + math.Log(float64(len(positions)))) * idf
+	}
+}
@@ -0,0 +1,172 @@
+package search
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestIndex(t *testing.T, dir string) *DiskIndex {
+	t.Helper()
+
+	d, err := OpenDiskIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// crash drops the index without writing a snapshot, as if the process died
+func crash(t *testing.T, d *DiskIndex) {
+	t.Helper()
+
+	d.compactions.Wait()
+	if err := d.log.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func indexTestDoc(t *testing.T, d *DiskIndex, id int, chatroom, message string) {
+	t.Helper()
+
+	err := d.Index(Document{ID: id, Chatroom: chatroom, Sender: "alice@example.com", Username: "alice", Message: message, Created: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func searchIDs(t *testing.T, d *DiskIndex, text string, chatrooms ...string) map[int]bool {
+	t.Helper()
+
+	hits, err := d.Search(Query{Text: text, Chatrooms: chatrooms})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[int]bool{}
+	for _, hit := range hits {
+		ids[hit.ID] = true
+	}
+
+	return ids
+}
+
+func TestDiskIndexReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	d := openTestIndex(t, dir)
+	indexTestDoc(t, d, 1, "general", "deploy tonight")
+	indexTestDoc(t, d, 2, "general", "deploy tomorrow")
+	indexTestDoc(t, d, 3, "ops", "deploy never")
+	if err := d.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Rename("ops", "platform"); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, d)
+
+	// a write cut short by the crash is skipped
+	f, err := os.OpenFile(d.logPath(d.gen), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"op":"index","doc":{"id":4,`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	d = openTestIndex(t, dir)
+	defer d.Close()
+
+	got := searchIDs(t, d, "deploy", "general", "ops", "platform")
+	want := map[int]bool{1: true, 3: true}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	if got := searchIDs(t, d, "deploy", "ops"); len(got) != 0 {
+		t.Errorf("got %v in the renamed chatroom; want none", got)
+	}
+}
+
+func TestDiskIndexCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	d := openTestIndex(t, dir)
+	for id := 1; id <= compactAfter+1; id++ {
+		indexTestDoc(t, d, id, "general", fmt.Sprintf("message number%d", id))
+	}
+	crash(t, d)
+
+	if d.compactErr != nil {
+		t.Fatal(d.compactErr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		t.Fatalf("snapshot not written: %v", err)
+	}
+
+	gens, err := d.logGens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gens) != 1 || gens[0] != d.snapGen {
+		t.Errorf("got logs %v; want only generation %d", gens, d.snapGen)
+	}
+
+	d = openTestIndex(t, dir)
+	defer d.Close()
+
+	if d.logged != 1 {
+		t.Errorf("got %d writes replayed; want 1", d.logged)
+	}
+	if got := len(searchIDs(t, d, "message", "general")); got != compactAfter+1 {
+		t.Errorf("got %d hits; want %d", got, compactAfter+1)
+	}
+	if got := searchIDs(t, d, "number1", "general"); !got[1] || len(got) != 1 {
+		t.Errorf("got %v; want only 1", got)
+	}
+}
+
+func TestDiskIndexClose(t *testing.T) {
+	dir := t.TempDir()
+
+	d := openTestIndex(t, dir)
+	indexTestDoc(t, d, 1, "general", "deploy tonight")
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d = openTestIndex(t, dir)
+	defer d.Close()
+
+	if d.logged != 0 {
+		t.Errorf("got %d writes replayed; want 0", d.logged)
+	}
+	if got := searchIDs(t, d, "tonight", "general"); !got[1] {
+		t.Errorf("got %v; want 1", got)
+	}
+}
+
+func TestDiskIndexReset(t *testing.T) {
+	dir := t.TempDir()
+
+	d := openTestIndex(t, dir)
+	indexTestDoc(t, d, 1, "general", "deploy tonight")
+	if err := d.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	indexTestDoc(t, d, 2, "general", "deploy tomorrow")
+	crash(t, d)
+
+	d = openTestIndex(t, dir)
+	defer d.Close()
+
+	got := searchIDs(t, d, "deploy", "general")
+	if got[1] || !got[2] {
+		t.Errorf("got %v; want only 2", got)
+	}
+}
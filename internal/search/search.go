@@ -0,0 +1,132 @@
+// Package search indexes chat messages so they can be searched without
+// scanning the chats table.
+package search
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Document is the part of a message the index needs
+type Document struct {
+	ID       int       `json:"id"`
+	Chatroom string    `json:"chatroom"`
+	Sender   string    `json:"sender"`
+	Username string    `json:"username"`
+	Message  string    `json:"message"`
+	Created  time.Time `json:"created"`
+}
+
+// Query is a search over the index. Text supports plain words, "quoted
+// phrases" and prefix* matches, every part of it must match.
+type Query struct {
+	Text string
+	// Chatrooms limits results to these rooms, no rooms means no results
+	Chatrooms []string
+	Chatroom  string
+	// Sender matches either the sender's email or username
+	Sender string
+	From   time.Time
+	// To is exclusive
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+type Hit struct {
+	ID    int
+	Score float64
+}
+
+// Indexer is fed every message write and answers searches. Index replaces any
+// document already indexed under the same id.
+type Indexer interface {
+	Index(doc Document) error
+	Delete(id int) error
+	Search(q Query) ([]Hit, error)
+	// Rename moves every document in chatroom from to chatroom to
+	Rename(from, to string) error
+	// Reset empties the index ahead of a rebuild
+	Reset() error
+	Close() error
+}
+
+const (
+	clauseTerm = iota
+	clausePrefix
+	clausePhrase
+)
+
+type clause struct {
+	kind  int
+	terms []string
+}
+
+// tokenize splits text into lowercase words, the same way for documents and queries
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// parseQuery splits query text into clauses. Words joined by punctuation,
+// like don't, are matched as a phrase.
+func parseQuery(text string) []clause {
+	clauses := []clause{}
+
+	for len(text) > 0 {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if text == "" {
+			break
+		}
+
+		if text[0] == '"' {
+			end := strings.IndexByte(text[1:], '"')
+			phrase := text[1:]
+			text = ""
+			if end >= 0 {
+				phrase, text = phrase[:end], phrase[end+1:]
+			}
+
+			if terms := tokenize(phrase); len(terms) > 0 {
+				clauses = append(clauses, newClause(clausePhrase, terms))
+			}
+			continue
+		}
+
+		end := strings.IndexFunc(text, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end < 0 {
+			end = len(text)
+		}
+		word := text[:end]
+		text = text[end:]
+
+		terms := tokenize(word)
+		if len(terms) == 0 {
+			continue
+		}
+
+		if strings.HasSuffix(word, "*") {
+			if len(terms) > 1 {
+				clauses = append(clauses, newClause(clausePhrase, terms[:len(terms)-1]))
+			}
+			clauses = append(clauses, clause{kind: clausePrefix, terms: terms[len(terms)-1:]})
+			continue
+		}
+
+		clauses = append(clauses, newClause(clausePhrase, terms))
+	}
+
+	return clauses
+}
+
+// newClause builds a phrase clause, falling back to a single term when the
+// phrase is only one word long
+func newClause(kind int, terms []string) clause {
+	if kind == clausePhrase && len(terms) == 1 {
+		kind = clauseTerm
+	}
+
+	return clause{kind: kind, terms: terms}
+}
@@ -0,0 +1,86 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []clause
+	}{
+		{
+			name: "Empty",
+			text: "   ",
+			want: []clause{},
+		},
+		{
+			name: "Words",
+			text: "Deploy  Tonight",
+			want: []clause{
+				{kind: clauseTerm, terms: []string{"deploy"}},
+				{kind: clauseTerm, terms: []string{"tonight"}},
+			},
+		},
+		{
+			name: "Phrase",
+			text: `"release notes" ready`,
+			want: []clause{
+				{kind: clausePhrase, terms: []string{"release", "notes"}},
+				{kind: clauseTerm, terms: []string{"ready"}},
+			},
+		},
+		{
+			name: "Unclosed phrase",
+			text: `"release notes`,
+			want: []clause{
+				{kind: clausePhrase, terms: []string{"release", "notes"}},
+			},
+		},
+		{
+			name: "One word phrase",
+			text: `"release"`,
+			want: []clause{
+				{kind: clauseTerm, terms: []string{"release"}},
+			},
+		},
+		{
+			name: "Prefix",
+			text: "deplo*",
+			want: []clause{
+				{kind: clausePrefix, terms: []string{"deplo"}},
+			},
+		},
+		{
+			name: "Joined words",
+			text: "don't",
+			want: []clause{
+				{kind: clausePhrase, terms: []string{"don", "t"}},
+			},
+		},
+		{
+			name: "Joined words with prefix",
+			text: "co-work*",
+			want: []clause{
+				{kind: clauseTerm, terms: []string{"co"}},
+				{kind: clausePrefix, terms: []string{"work"}},
+			},
+		},
+		{
+			name: "Punctuation only",
+			text: "*** --",
+			want: []clause{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseQuery(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package search
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// SQLIndex searches the chats table's full-text index. MySQL keeps that index
+// up to date itself so writes and resets do nothing.
+type SQLIndex struct {
+	DB *sql.DB
+}
+
+func (s *SQLIndex) Index(doc Document) error { return nil }
+
+func (s *SQLIndex) Delete(id int) error { return nil }
+
+func (s *SQLIndex) Rename(from, to string) error { return nil }
+
+func (s *SQLIndex) Reset() error { return nil }
+
+func (s *SQLIndex) Close() error { return nil }
+
+// booleanQuery converts clauses to MySQL's boolean mode syntax, every clause is required
+func booleanQuery(clauses []clause) string {
+	parts := []string{}
+
+	for _, c := range clauses {
+		switch c.kind {
+		case clauseTerm:
+			parts = append(parts, "+"+c.terms[0])
+		case clausePrefix:
+			parts = append(parts, "+"+c.terms[0]+"*")
+		case clausePhrase:
+			parts = append(parts, `+"`+strings.Join(c.terms, " ")+`"`)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func (s *SQLIndex) Search(q Query) ([]Hit, error) {
+	clauses := parseQuery(q.Text)
+	if len(clauses) == 0 || len(q.Chatrooms) == 0 {
+		return []Hit{}, nil
+	}
+	text := booleanQuery(clauses)
+
+	stmt := `SELECT id, MATCH (message) AGAINST (? IN BOOLEAN MODE) AS score FROM chats
+	WHERE MATCH (message) AGAINST (? IN BOOLEAN MODE)
+	AND chatroom IN (?` + strings.Repeat(", ?", len(q.Chatrooms)-1) + `)`
+	args := []any{text, text}
+	for _, chatroom := range q.Chatrooms {
+		args = append(args, chatroom)
+	}
+
+	if q.Chatroom != "" {
+		stmt += ` AND chatroom = ?`
+		args = append(args, q.Chatroom)
+	}
+	if q.Sender != "" {
+		stmt += ` AND (sender = ? OR username = ?)`
+		args = append(args, q.Sender, q.Sender)
+	}
+	if !q.From.IsZero() {
+		stmt += ` AND created >= ?`
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		stmt += ` AND created < ?`
+		args = append(args, q.To.UTC())
+	}
+
+	stmt += ` ORDER BY score DESC, created DESC LIMIT ? OFFSET ?`
+	args = append(args, q.Limit, q.Offset)
+
+	rows, err := s.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []Hit{}
+
+	for rows.Next() {
+		h := Hit{}
+		if err := rows.Scan(&h.ID, &h.Score); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hits, nil
+}
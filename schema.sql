@@ -0,0 +1,273 @@
+-- Tables added on top of the users, chats, chatrooms and sessions tables.
+
+CREATE TABLE mentions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    chat_id INTEGER NOT NULL,
+    user VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_mentions_user ON mentions(user);
+
+CREATE TABLE keywords (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user VARCHAR(255) NOT NULL,
+    keyword VARCHAR(100) NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT keywords_uc_user_keyword UNIQUE (user, keyword)
+);
+
+CREATE TABLE alerts (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    chat_id INTEGER NOT NULL,
+    user VARCHAR(255) NOT NULL,
+    keyword VARCHAR(100) NOT NULL,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_alerts_user ON alerts(user);
+
+CREATE TABLE pins (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    chat_id INTEGER NOT NULL,
+    chatroom VARCHAR(255) NOT NULL,
+    pinned_by VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT pins_uc_chat_id UNIQUE (chat_id),
+    FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_pins_chatroom ON pins(chatroom);
+
+CREATE TABLE bookmarks (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user VARCHAR(255) NOT NULL,
+    chat_id INTEGER NOT NULL,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created DATETIME NOT NULL,
+    CONSTRAINT bookmarks_uc_user_chat_id UNIQUE (user, chat_id),
+    FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+
+CREATE TABLE forwards (
+    chat_id INTEGER NOT NULL PRIMARY KEY,
+    chatroom VARCHAR(255) NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+
+CREATE TABLE scheduled_messages (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    chatroom VARCHAR(255) NOT NULL,
+    sender VARCHAR(255) NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    message TEXT NOT NULL,
+    send_at DATETIME NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_scheduled_messages_send_at ON scheduled_messages(send_at);
+
+CREATE TABLE reminders (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user VARCHAR(255) NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    chatroom VARCHAR(255) NOT NULL DEFAULT '',
+    message TEXT NOT NULL,
+    remind_at DATETIME NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_reminders_remind_at ON reminders(remind_at);
+
+CREATE TABLE rooms (
+    name VARCHAR(255) NOT NULL PRIMARY KEY,
+    topic VARCHAR(255) NOT NULL DEFAULT '',
+    updated DATETIME NOT NULL
+);
+
+CREATE TABLE polls (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    chat_id INTEGER NOT NULL,
+    chatroom VARCHAR(255) NOT NULL,
+    creator VARCHAR(255) NOT NULL,
+    question VARCHAR(255) NOT NULL,
+    multiple BOOLEAN NOT NULL DEFAULT FALSE,
+    anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+    closes_at DATETIME NULL,
+    closed BOOLEAN NOT NULL DEFAULT FALSE,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_polls_chatroom ON polls(chatroom);
+
+CREATE TABLE poll_options (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    poll_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    text VARCHAR(100) NOT NULL,
+    FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+);
+
+CREATE TABLE poll_votes (
+    poll_id INTEGER NOT NULL,
+    option_id INTEGER NOT NULL,
+    user VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (option_id, user),
+    FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE,
+    FOREIGN KEY (option_id) REFERENCES poll_options(id) ON DELETE CASCADE
+);
+
+ALTER TABLE rooms ADD COLUMN disappear_after INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- NULL follows the global -retention-days policy, 0 keeps messages forever
+ALTER TABLE rooms ADD COLUMN retention_days INTEGER NULL;
+
+CREATE TABLE holds (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    kind ENUM('user', 'room') NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    created_by VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_holds_kind_target ON holds(kind, target);
+
+-- One row per deletion a hold stopped, kind and target describe what was being deleted
+CREATE TABLE hold_audit (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    action VARCHAR(50) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    actor VARCHAR(255) NOT NULL DEFAULT '',
+    blocked INTEGER NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_hold_audit_created ON hold_audit(created);
+
+ALTER TABLE chats ADD FULLTEXT INDEX ft_chats_message (message);
+
+-- open rooms can be joined by name, invite rooms only by invitation or invite link
+ALTER TABLE rooms ADD COLUMN join_policy VARCHAR(10) NOT NULL DEFAULT 'open';
+
+CREATE TABLE invitations (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    chatroom VARCHAR(255) NOT NULL,
+    inviter VARCHAR(255) NOT NULL,
+    invitee VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT invitations_uc_chatroom_invitee UNIQUE (chatroom, invitee)
+);
+
+CREATE INDEX idx_invitations_invitee ON invitations(invitee);
+
+-- max_uses 0 allows any number of uses, NULL expires never expires
+CREATE TABLE invite_links (
+    token CHAR(22) NOT NULL PRIMARY KEY,
+    chatroom VARCHAR(255) NOT NULL,
+    creator VARCHAR(255) NOT NULL,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    uses INTEGER NOT NULL DEFAULT 0,
+    expires DATETIME NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_invite_links_chatroom ON invite_links(chatroom);
+
+-- rooms.join_policy may also be 'knock', where non-members ask to join and wait for a moderator
+CREATE TABLE join_requests (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    chatroom VARCHAR(255) NOT NULL,
+    user VARCHAR(255) NOT NULL,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created DATETIME NOT NULL,
+    CONSTRAINT join_requests_uc_chatroom_user UNIQUE (chatroom, user)
+);
+
+-- the first member of each existing group chatroom becomes its owner
+ALTER TABLE chatrooms ADD COLUMN role VARCHAR(10) NOT NULL DEFAULT 'member';
+
+UPDATE chatrooms JOIN (SELECT MIN(id) AS id FROM chatrooms WHERE private = FALSE GROUP BY name) AS first
+ON first.id = chatrooms.id SET chatrooms.role = 'owner';
+
+-- kicks, bans and mutes with who did them and why, NULL expires lasts until lifted
+CREATE TABLE moderation_actions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    chatroom VARCHAR(255) NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(10) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    expires DATETIME NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_moderation_actions_chatroom_target ON moderation_actions(chatroom, target);
+
+ALTER TABLE rooms ADD COLUMN description VARCHAR(1000) NOT NULL DEFAULT '';
+
+-- avatar_updated is NULL for rooms without an avatar
+ALTER TABLE rooms ADD COLUMN avatar_updated DATETIME NULL;
+
+CREATE TABLE room_avatars (
+    name VARCHAR(255) NOT NULL PRIMARY KEY,
+    content_type VARCHAR(50) NOT NULL,
+    image MEDIUMBLOB NOT NULL
+);
+
+-- archived rooms are read only and left out of room lists, NULL while active
+ALTER TABLE rooms ADD COLUMN archived DATETIME NULL;
+
+-- announcement rooms only let moderators post, auto_join adds new users at signup
+ALTER TABLE rooms ADD COLUMN announcement BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE rooms ADD COLUMN auto_join BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- slow_mode is the seconds members must leave between messages, 0 is off
+ALTER TABLE rooms ADD COLUMN slow_mode INTEGER NOT NULL DEFAULT 0;
+
+-- dm_policy is who can start private chatrooms with the user: everyone, shared, contacts or nobody
+ALTER TABLE users ADD COLUMN dm_policy VARCHAR(10) NOT NULL DEFAULT 'everyone';
+
+CREATE TABLE contacts (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user VARCHAR(255) NOT NULL,
+    contact VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT contacts_uc_user_contact UNIQUE (user, contact)
+);
+
+-- disappear_since is when disappearing messages were turned on, older messages are kept
+ALTER TABLE rooms ADD COLUMN disappear_since DATETIME NULL;
+
+-- avatar_hash is the start of the avatar image's SHA-256, it versions the avatar's URL
+ALTER TABLE rooms ADD COLUMN avatar_hash CHAR(16) NOT NULL DEFAULT '';
+UPDATE rooms INNER JOIN room_avatars ON room_avatars.name = rooms.name
+SET rooms.avatar_hash = LEFT(SHA2(room_avatars.image, 256), 16);
+
+-- members is the SHA-256 of a group chat's sorted member emails joined by newlines so
+-- two group chats between the same people cannot be started at once. It is NULL
+-- when another group chat already has the same members.
+CREATE TABLE group_chats (
+    name VARCHAR(255) NOT NULL PRIMARY KEY,
+    members CHAR(64) NULL,
+    CONSTRAINT group_chats_uc_members UNIQUE (members)
+);
+
+SET SESSION group_concat_max_len = 65536;
+
+INSERT INTO group_chats (name) SELECT DISTINCT name FROM chatrooms WHERE name LIKE 'Group chat %';
+
+UPDATE IGNORE group_chats INNER JOIN (
+    SELECT name, SHA2(GROUP_CONCAT(user ORDER BY CAST(user AS BINARY) SEPARATOR '\n'), 256) AS members
+    FROM chatrooms WHERE name LIKE 'Group chat %' GROUP BY name
+) AS sets ON sets.name = group_chats.name
+SET group_chats.members = sets.members;